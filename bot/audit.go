@@ -0,0 +1,259 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/user"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+	"github.com/readysetliqd/airdrop-discord-bot-go/storage"
+)
+
+// auditEntriesShown is the most audit entries listed by /audit
+const auditEntriesShown = 15
+
+// twitterSetting is the Setting of audit entries changing a protocol's twitter
+const twitterSetting = "twitter"
+
+// memberAudit returns an audit entry for 'action' taken by 'member' through a
+// command
+func memberAudit(member *discordgo.Member, action string) data.AuditEntry {
+	entry := data.AuditEntry{Action: action}
+	if member != nil && member.User != nil {
+		entry.UserID = member.User.ID
+		entry.Username = member.User.Username
+	}
+	return entry
+}
+
+// reactionAudit returns an audit entry for 'action' taken by reacting with
+// 'm'. The member is missing for reactions outside a guild, the user ID isn't
+func reactionAudit(m *discordgo.MessageReactionAdd, action string) data.AuditEntry {
+	entry := memberAudit(m.Member, action)
+	entry.UserID = m.UserID
+	return entry
+}
+
+// botAudit returns an audit entry for 'action' taken by the bot on its own
+func botAudit(action string) data.AuditEntry {
+	return data.AuditEntry{Action: action, UserID: BotId, Username: "bot"}
+}
+
+// cliAudit returns an audit entry for 'action' taken from the command line.
+// There's no discord user, the entry is signed by the system user running it
+func cliAudit(action string) data.AuditEntry {
+	entry := data.AuditEntry{Action: action, Username: "cli"}
+	if u, err := user.Current(); err == nil {
+		entry.Username = "cli " + u.Username
+	}
+	return entry
+}
+
+// recordAudit stamps 'entry' with the current time and adds it to the audit
+// trail in 'tx'
+func recordAudit(tx storage.Tx, entry data.AuditEntry) error {
+	entry.Time = time.Now().UTC()
+	// keys sort by time, the user ID keeps actions taken at the same moment apart
+	key := entry.Time.Format(time.RFC3339Nano) + " " + entry.UserID
+	return tx.Put(storage.AuditBucket, key, entry)
+}
+
+// audit adds 'entry' to the audit trail in its own transaction. Errors are
+// logged, the action it records has already happened
+func audit(entry data.AuditEntry) {
+	err := store.Update(func(tx storage.Tx) error {
+		return recordAudit(tx, entry)
+	})
+	if err != nil {
+		log.Printf("recording %s by %s in the audit trail | %v\n", entry.Action, entry.UserID, err)
+	}
+}
+
+// auditValue returns 'v' encoded as json for the audit trail, empty for nil
+func auditValue(v any) string {
+	if v == nil {
+		return ""
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(raw)
+}
+
+// changeTwitterURL sets the twitter url of the protocol 'name' with
+// setTwitterURL and records the old and new url in the audit trail as 'entry'
+func changeTwitterURL(entry data.AuditEntry, name, twitterURL string) {
+	previousURL := protocols.M[name].TwitterURL
+	setTwitterURL(name, twitterURL)
+	entry.Protocol, entry.Setting = name, twitterSetting
+	entry.Old, entry.New = auditValue(previousURL), auditValue(protocols.M[name].TwitterURL)
+	audit(entry)
+}
+
+// auditEntries returns the audit entries by the user 'userID' about the
+// protocol 'protocol', newest first. Either filter is skipped when empty
+func auditEntries(userID, protocol string) ([]data.AuditEntry, error) {
+	var entries []data.AuditEntry
+	err := store.View(func(tx storage.Tx) error {
+		if protocol == "" && userID == "" {
+			return tx.ForEach(storage.AuditBucket, func(key string, raw json.RawMessage) error {
+				var entry data.AuditEntry
+				err := json.Unmarshal(raw, &entry)
+				if err != nil {
+					return data.JsonMarshalError{OriginalErr: err}
+				}
+				entries = append(entries, entry)
+				return nil
+			})
+		}
+
+		var keys []string
+		var err error
+		if protocol != "" {
+			keys, err = tx.Lookup(storage.AuditBucket, storage.ProtocolIndex, protocol)
+		} else {
+			keys, err = tx.Lookup(storage.AuditBucket, storage.UserIndex, userID)
+		}
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			var entry data.AuditEntry
+			ok, err := tx.Get(storage.AuditBucket, key, &entry)
+			if err != nil {
+				return err
+			}
+			if ok && (userID == "" || entry.UserID == userID) {
+				entries = append(entries, entry)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading bucket %s | %w", storage.AuditBucket, err)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.After(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// auditText returns an Old or New value of an audit entry as text
+func auditText(value string) string {
+	var text string
+	if json.Unmarshal([]byte(value), &text) == nil {
+		value = text
+	}
+	return orNA(truncate(value, 100))
+}
+
+// auditLine returns 'entry' as a line of the /audit reply
+func auditLine(entry data.AuditEntry) string {
+	var line strings.Builder
+	who := fmt.Sprintf("<@%s>", entry.UserID)
+	if entry.UserID == "" {
+		who = entry.Username
+	}
+	fmt.Fprintf(&line, "<t:%d:f> %s %s", entry.Time.Unix(), who, entry.Action)
+	if entry.Protocol != "" {
+		fmt.Fprintf(&line, " **%s**", entry.Protocol)
+	}
+	if entry.Setting != "" {
+		fmt.Fprintf(&line, " %s: %s → %s", entry.Setting, auditText(entry.Old), auditText(entry.New))
+	}
+	return line.String()
+}
+
+// auditCommandHandler responds to the /audit command with the latest audit
+// entries, filtered by the 'user' and 'protocol' options
+func auditCommandHandler(s discordSession, i *discordgo.InteractionCreate) {
+	var userID, protocol string
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "user":
+			userID = optionID(option)
+		case "protocol":
+			protocol = strings.TrimSpace(option.StringValue())
+			if found, ok := findProtocol(protocol); ok {
+				protocol = found.Name
+			}
+		}
+	}
+	entries, err := auditEntries(userID, protocol)
+	if err != nil {
+		log.Println("audit |", err)
+		interactionReply(s, i, "Couldn't read the audit log, check the bot's logs.")
+		return
+	}
+	if len(entries) == 0 {
+		interactionReply(s, i, "No audit entries found.")
+		return
+	}
+
+	var lines strings.Builder
+	for n, entry := range entries {
+		if n == auditEntriesShown {
+			fmt.Fprintf(&lines, "and %d older", len(entries)-auditEntriesShown)
+			break
+		}
+		lines.WriteString(auditLine(entry) + "\n")
+	}
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{{
+				Title:       "Audit log",
+				Color:       embedColor,
+				Description: truncate(lines.String(), 4096),
+			}},
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		},
+	})
+	if err != nil {
+		log.Println("responding to interaction |", err)
+	}
+}
+
+// undoTwitterCommandHandler responds to the /undo-twitter command by putting
+// back the twitter url the protocol 'name' had before its latest recorded
+// twitter change. Undoing twice redoes the change
+func undoTwitterCommandHandler(s discordSession, i *discordgo.InteractionCreate) {
+	name := i.ApplicationCommandData().Options[0].StringValue()
+	protocol, ok := findProtocol(name)
+	if !ok {
+		interactionReply(s, i, "No protocol by that name exists, check spelling.")
+		return
+	}
+	entries, err := auditEntries("", protocol.Name)
+	if err != nil {
+		log.Println("undo-twitter |", err)
+		interactionReply(s, i, "Couldn't read the audit log, check the bot's logs.")
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.Setting != twitterSetting {
+			continue
+		}
+		var previousURL string
+		if entry.Old != "" {
+			err = json.Unmarshal([]byte(entry.Old), &previousURL)
+			if err != nil {
+				log.Printf("undo-twitter decoding %s | %v\n", entry.Old, err)
+				interactionReply(s, i, "Couldn't read the previous twitter url, check the bot's logs.")
+				return
+			}
+		}
+		changeTwitterURL(memberAudit(i.Member, "undo-twitter"), protocol.Name, previousURL)
+		interactionReply(s, i, fmt.Sprintf("Set twitter of %s back to %s from before %s by <@%s> <t:%d:R>.",
+			protocol.Name, orNA(previousURL), entry.Action, entry.UserID, entry.Time.Unix()))
+		return
+	}
+	interactionReply(s, i, fmt.Sprintf("No twitter changes recorded for %s.", protocol.Name))
+}
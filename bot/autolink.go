@@ -0,0 +1,234 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+	"google.golang.org/api/customsearch/v1"
+)
+
+// handleSuffixes and handlePrefixes are words projects commonly add to their
+// name to get a free twitter handle, e.g. @uniswapfdn or @getfoo
+var (
+	handleSuffixes = []string{"hq", "xyz", "io", "fi", "labs", "protocol", "network", "app", "finance", "dao", "official", "fdn", "foundation", "chain", "dex", "global"}
+	handlePrefixes = []string{"get", "use", "join", "try", "the", "go"}
+)
+
+// matchHandleConfidence rates from 0 to 1 how likely the twitter profile at
+// 'candidateURL' belongs to the protocol 'name' with token 'symbol'. The handle
+// is compared against the name and symbol, the search result title and
+// snippet can add a little on top. Urls that aren't twitter profiles score 0
+func matchHandleConfidence(candidateURL, title, snippet, name, symbol string) float64 {
+	handle := alphanumeric(twitterHandleFromURL(candidateURL))
+	if handle == "" {
+		return 0
+	}
+	n := alphanumeric(name)
+	sym := alphanumeric(symbol)
+
+	var confidence float64
+	switch {
+	case n == "":
+		confidence = 0
+	case handle == n:
+		confidence = 0.9
+	case hasAffix(handle, n):
+		confidence = 0.8
+	case sym != "" && handle == sym:
+		confidence = 0.6
+	case len(n) >= 4 && strings.Contains(handle, n):
+		confidence = 0.6
+	case len(handle) >= 4 && strings.Contains(n, handle):
+		confidence = 0.5
+	default:
+		confidence = 0.1
+	}
+
+	if stringContainsCaseIns(title, name) {
+		confidence += 0.05
+	}
+	if stringContainsCaseIns(title, "@"+twitterHandleFromURL(candidateURL)) {
+		confidence += 0.05
+	}
+	if stringContainsCaseIns(snippet, name) {
+		confidence += 0.03
+	}
+	if confidence > 0.99 {
+		confidence = 0.99
+	}
+	return confidence
+}
+
+// hasAffix returns true if 'handle' is 'name' with one of the common handle
+// prefixes or suffixes added
+func hasAffix(handle, name string) bool {
+	for _, suffix := range handleSuffixes {
+		if handle == name+suffix {
+			return true
+		}
+	}
+	for _, prefix := range handlePrefixes {
+		if handle == prefix+name {
+			return true
+		}
+	}
+	return false
+}
+
+// twitterHandleFromURL returns the handle of a twitter or x profile url, or an
+// empty string if the url isn't on twitter or x
+func twitterHandleFromURL(rawURL string) string {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(u.Hostname()), "www."), "mobile.")
+	if host != "twitter.com" && host != "x.com" {
+		return ""
+	}
+	segment, _, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if segment == "" || isReservedTwitterPath(segment) {
+		return ""
+	}
+	return segment
+}
+
+// twitterHandlePattern matches a valid twitter handle
+var twitterHandlePattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,15}$`)
+
+// normalizeTwitterURL turns a twitter or x url into the canonical
+// https://x.com/<handle> profile url and returns it with the handle. Tweets,
+// mobile links and urls without a scheme are converted to their profile, a
+// bare @handle is accepted too. Returns false for anything that isn't a profile
+func normalizeTwitterURL(rawURL string) (string, string, bool) {
+	rawURL = strings.TrimSpace(rawURL)
+	handle := strings.TrimPrefix(rawURL, "@")
+	if handle == rawURL {
+		handle = twitterHandleFromURL(rawURL)
+	}
+	if !twitterHandlePattern.MatchString(handle) || isReservedTwitterPath(handle) {
+		return "", "", false
+	}
+	return "https://x.com/" + handle, handle, true
+}
+
+// alphanumeric lowercases 's' and drops anything that isn't a letter or digit
+func alphanumeric(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// searchCandidates turns Google search results into twitter candidates for the
+// protocol 'name' with token 'symbol', rated by matchHandleConfidence. Results
+// that aren't on twitter are dropped, tweets are converted to their profile
+func searchCandidates(resp *customsearch.Search, name, symbol string) []HandleCandidate {
+	candidates := make([]HandleCandidate, 0, len(resp.Items))
+	for _, result := range resp.Items {
+		profileURL, _, ok := normalizeTwitterURL(result.FormattedUrl)
+		if !ok {
+			continue
+		}
+		candidates = append(candidates, HandleCandidate{
+			URL:        profileURL,
+			Title:      result.Title,
+			Snippet:    result.Snippet,
+			Confidence: matchHandleConfidence(result.FormattedUrl, result.Title, result.Snippet, name, symbol),
+			Source:     "google",
+		})
+	}
+	return candidates
+}
+
+// bestCandidate returns the candidate with the highest confidence and true if
+// it's at least config.HandleConfidence
+func bestCandidate(candidates []HandleCandidate) (HandleCandidate, bool) {
+	var best HandleCandidate
+	for _, candidate := range candidates {
+		if candidate.Confidence > best.Confidence {
+			best = candidate
+		}
+	}
+	return best, best.Confidence >= conf().HandleConfidence
+}
+
+// autoLinkTwitter posts a notice to the channel 'channelID' that operators can
+// react ❌ to in order to undo it, then stores 'candidate' as the twitter of
+// the protocol 'name'. 'parent' is the round message that would otherwise be
+// waiting for an operator, it's restored as pending on undo. The change is
+// recorded in the audit trail as 'entry'. Nothing is linked when the notice
+// can't be sent so the round can be left to an operator instead
+func autoLinkTwitter(s discordSession, entry data.AuditEntry, channelID, name string, candidate HandleCandidate, parent data.UnprocessedMessage, parentMsgID string) error {
+	previousURL := protocols.M[name].TwitterURL
+	content := fmt.Sprintf("Auto-linked **%s** to <%s> (%s, %.0f%% match), react ❌ to undo.", name, candidate.URL, candidate.Source, candidate.Confidence*100)
+	msg, err := s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Content:         content,
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	if err != nil {
+		return fmt.Errorf("sending auto-link notice to channel %s | %w", channelID, discordError(err))
+	}
+	changeTwitterURL(entry, name, candidate.URL)
+	unprocessedMessages.M[msg.ID] = data.UnprocessedMessage{
+		Type:            data.AutoLinkNotice,
+		ProtocolName:    name,
+		Symbol:          parent.Symbol,
+		Embeds:          parent.Embeds,
+		ChannelID:       channelID,
+		ParentMsgID:     parentMsgID,
+		ParentChannelID: messageChannel(parent),
+		PreviousURL:     previousURL,
+		New:             true,
+	}
+	err = s.MessageReactionAdd(channelID, msg.ID, "❌")
+	if err != nil {
+		// the link is done, operators can still react ❌ to the notice themselves
+		log.Printf("adding undo reaction to auto-link notice %s | %v\n", msg.ID, err)
+	}
+	return nil
+}
+
+// undoAutoLink handles ❌ on an auto-link notice. It puts back the protocol's
+// previous twitter url, makes the round message pending again with its twitter
+// reaction and deletes the notice
+func undoAutoLink(s discordSession, m *discordgo.MessageReactionAdd) {
+	notice := unprocessedMessages.M[m.MessageID]
+	changeTwitterURL(reactionAudit(m, "undo-auto-link"), notice.ProtocolName, notice.PreviousURL)
+
+	if notice.ParentMsgID != "" {
+		unprocessedMessages.M[notice.ParentMsgID] = data.UnprocessedMessage{
+			Type:         data.RoundMsg,
+			ProtocolName: notice.ProtocolName,
+			Symbol:       notice.Symbol,
+			Embeds:       notice.Embeds,
+			ChannelID:    parentChannel(notice),
+			New:          true,
+		}
+		err := s.MessageReactionAdd(parentChannel(notice), notice.ParentMsgID, conf().TwitterEmoji())
+		if err != nil {
+			log.Println("failed to add reaction back to message id ", notice.ParentMsgID, " | ", err)
+		}
+	}
+
+	err := s.ChannelMessageDelete(m.ChannelID, m.MessageID)
+	if err != nil {
+		log.Println("deleting message |", err)
+	}
+	if !notice.New {
+		unprocessedMessages.Modified = true
+	}
+	delete(unprocessedMessages.M, m.MessageID)
+}
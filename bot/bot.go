@@ -12,16 +12,16 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"sort"
-	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/readysetliqd/airdrop-discord-bot-go/config"
 	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+	"github.com/readysetliqd/airdrop-discord-bot-go/storage"
 	"google.golang.org/api/customsearch/v1"
-	"google.golang.org/api/option"
 
 	"github.com/bwmarrin/discordgo"
 )
@@ -29,7 +29,13 @@ import (
 var BotId string
 var unprocessedMessages *data.UnprocessedMessages
 var protocols *data.Protocols
-var s *discordgo.Session
+var funds *data.Funds
+var store storage.Storage
+
+// storeInMemory is set when changes are kept in memory and never stored, in
+// shadow mode and while replaying. Loading then only reads the files on disk
+var storeInMemory bool
+var s discordSession
 
 var (
 	commands = []*discordgo.ApplicationCommand{
@@ -46,14 +52,236 @@ var (
 					Name:        "protocol",
 					Description: "Name of protocol, case and other character sensitive",
 					Required:    true,
-					//TODO after main.go bot logic is moved here, add choices from list of protocols on loading
+				},
+			},
+		},
+		{
+			Name:        "fund",
+			Description: "Shows a fund's tier, recent rounds, favourite categories and average ticket size",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "name",
+					Description: "Name or cryptorank key of the fund, case insensitive",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name:        "protocol",
+			Description: "Shows a protocol's links, chains and every funding round recorded for it",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "name",
+					Description: "Name or cryptorank key of the protocol, case insensitive",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name:        "edit-protocol",
+			Description: "Sets or clears a link of a protocol",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "name",
+					Description: "Name or cryptorank key of the protocol, case insensitive",
+					Required:    true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "field",
+					Description: "Field to edit",
+					Required:    true,
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: "twitter", Value: "twitter"},
+						{Name: "website", Value: "website"},
+						{Name: "docs", Value: "docs"},
+						{Name: "discord", Value: "discord"},
+						{Name: "github", Value: "github"},
+						{Name: "telegram", Value: "telegram"},
+						{Name: "chains", Value: "chains"},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "value",
+					Description: "New value, chains comma separated. Leave out to clear the field",
+				},
+			},
+		},
+		{
+			Name:        "reload-config",
+			Description: "Reloads the config file without restarting",
+		},
+		{
+			Name:        "search-quota",
+			Description: "Shows how many Google searches are used and left today",
+		},
+		{
+			Name:        "config",
+			Description: "Views or changes the bot's settings",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "view",
+					Description: "Shows the settings in use",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "set-channel",
+					Description: "Sets a channel, leave out the channel to go back to config.json",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "setting",
+							Description: "Channel to set",
+							Required:    true,
+							Choices:     settingChoices(configChannels),
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionChannel,
+							Name:        "channel",
+							Description: "New channel",
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "set-role",
+					Description: "Sets a role, leave out the role to go back to config.json",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "setting",
+							Description: "Role to set",
+							Required:    true,
+							Choices:     settingChoices(configRoles),
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionRole,
+							Name:        "role",
+							Description: "New role",
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "add-rule",
+					Description: "Adds a ping rule tagging a role for matching funding rounds",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionRole,
+							Name:        "role",
+							Description: "Role to tag",
+							Required:    true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "fund",
+							Description: "Part of the name of a fund in the round",
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Name:        "tier",
+							Description: "Only check funds of this tier",
+							MinValue:    &minPingRuleTier,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "stage",
+							Description: "Part of the round's stage e.g. Seed",
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "remove-rule",
+					Description: "Removes a ping rule by its number in /config view",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Name:        "number",
+							Description: "Number of the rule",
+							Required:    true,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "set-schedule",
+					Description: "Sets the time the daily job runs, leave out the time to go back to config.json",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "time",
+							Description: "Time of day as HH:MM in UTC",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:        "audit",
+			Description: "Shows the latest operator actions",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionUser,
+					Name:        "user",
+					Description: "Only actions by this user",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "protocol",
+					Description: "Only actions on this protocol",
+				},
+			},
+		},
+		{
+			Name:        "run-recap",
+			Description: "Runs the daily job for rounds dated from one day to another",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "from",
+					Description: "First date as YYYY-MM-DD",
+					Required:    true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "to",
+					Description: "Last date as YYYY-MM-DD",
+					Required:    true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "dry_run",
+					Description: "Only show you what would be posted and pinged",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionChannel,
+					Name:        "channel",
+					Description: "Post here instead of the announcement channel, without threads",
+				},
+			},
+		},
+		{
+			Name:        "undo-twitter",
+			Description: "Puts back a protocol's twitter from before its last change",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "name",
+					Description: "Protocol name",
+					Required:    true,
 				},
 			},
 		},
 	}
 
-	commandHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
-		"list-protocols": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	commandHandlers = map[string]func(s discordSession, i *discordgo.InteractionCreate){
+		"list-protocols": func(s discordSession, i *discordgo.InteractionCreate) {
 			protocolNames := make([]string, len(protocols.M))
 			idx := 0
 			for _, protocol := range protocols.M {
@@ -74,7 +302,7 @@ var (
 				},
 			})
 		},
-		"get-twitter": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		"get-twitter": func(s discordSession, i *discordgo.InteractionCreate) {
 			protocolName := i.ApplicationCommandData().Options[0].StringValue()
 			var content string
 			// check protocols map for protocol name (string) sent as get-twitter command options
@@ -97,79 +325,86 @@ var (
 				},
 			})
 		},
+		"fund":          fundCommandHandler,
+		"protocol":      protocolCommandHandler,
+		"edit-protocol": editProtocolCommandHandler,
+		"reload-config": reloadConfigCommandHandler,
+		"search-quota":  searchQuotaCommandHandler,
+		"config":        configCommandHandler,
+		"audit":         auditCommandHandler,
+		"run-recap":     runRecapCommandHandler,
+		"undo-twitter":  undoTwitterCommandHandler,
 	}
 )
 
-func Start() error {
-	// load files into maps and pass up to global scope
-	protocols = loadProtocols()
-	unprocessedMessages = loadUnprocessedMessages()
-	var err error
-
-	s, err = discordgo.New("Bot " + config.Token)
+// Start runs the bot with 'cfg' until it receives SIGINT or SIGTERM. 'reload'
+// loads the config again for SIGHUP and the /reload-config command, it can be
+// nil if the config can't be reloaded
+func Start(cfg *config.Config, reload func() (*config.Config, error)) error {
+	configLoader = reload
+	// in shadow mode changes are kept in memory, the stored data is only read
+	err := openStores(cfg, cfg.ShadowMode != "")
 	if err != nil {
 		return err
 	}
+	pruneSearchCache()
 
-	u, err := s.User("@me")
-
+	session, err := newSession()
 	if err != nil {
 		return err
 	}
 
-	BotId = u.ID
-
-	s.AddHandler(messageHandler)
-	s.AddHandler(reactionHandler)
-	s.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-		if h, ok := commandHandlers[i.ApplicationCommandData().Name]; ok {
+	session.AddHandler(func(_ *discordgo.Session, m *discordgo.MessageCreate) {
+		messageHandler(s, m)
+	})
+	session.AddHandler(func(_ *discordgo.Session, m *discordgo.MessageReactionAdd) {
+		reactionHandler(s, shadowReaction(m))
+	})
+	session.AddHandler(func(_ *discordgo.Session, i *discordgo.InteractionCreate) {
+		if h, ok := commandHandlers[i.ApplicationCommandData().Name]; ok && commandAllowed(s, i) {
 			h(s, i)
 		}
 	})
-	s.AddHandler(func(s *discordgo.Session, r *discordgo.Ready) {
-		log.Printf("Logged in as: %v#%v", s.State.User.Username, s.State.User.Discriminator)
+	session.AddHandler(func(session *discordgo.Session, r *discordgo.Ready) {
+		log.Printf("Logged in as: %v#%v", session.State.User.Username, session.State.User.Discriminator)
 	})
 
-	err = s.Open()
-
-	log.Println("Adding commands...")
-	registeredCommands := make([]*discordgo.ApplicationCommand, len(commands))
-	for i, v := range commands {
-		cmd, err := s.ApplicationCommandCreate(s.State.User.ID, config.GuildID, v)
-		if err != nil {
-			log.Panicf("Cannot create '%v' command: %v", v.Name, err)
-		}
-		registeredCommands[i] = cmd
+	err = session.Open()
+	if err != nil {
+		return err
 	}
 
+	log.Println("Adding commands...")
+	err = registerCommands(session)
 	if err != nil {
 		return err
 	}
 
 	fmt.Println("Bot is running!")
 
-	// start ticker for daily queries to cryptorank
-	tickerDelay := 24 * time.Hour
-	ticker := time.NewTicker(tickerDelay)
+	if len(loadReports) > 0 {
+		sendOperatorAlert("Recovered store files on startup", errors.New(strings.Join(loadReports, "\n")))
+	}
+
 	shutdownSignals := make(chan os.Signal, 1)
 	signal.Notify(shutdownSignals, syscall.SIGINT, syscall.SIGTERM)
 
-	// main go routine loop to query cryptorank, send messages to discord, and add new rounds to rounds file
+	// main go routine loop to query cryptorank, send messages to discord, and add new rounds to rounds file.
+	// failures are reported to the operators, the bot keeps serving commands either way
+	go runDailyJob()
+
+	// reload the config on SIGHUP, a config that doesn't validate is reported
+	// and the current one kept
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
 	go func() {
-		for range ticker.C {
-			start := time.Now().Add(time.Hour * -24).Format("2006-01-02")
-			//TODO add err to this function
-			respDataStructs := queryCryptoRank(start)
-			err = sendFundingRoundsRecapEmbed(respDataStructs, start)
+		for range reloadSignals {
+			result, err := reloadConfigAndReport()
 			if err != nil {
-				log.Println(err)
-				shutdownSignals <- syscall.SIGTERM
-			}
-			//TODO add err to this function
-			rounds := sendIndividualFundingRoundsEmbeds(respDataStructs)
-			if len(rounds) > 0 {
-				AppendToFile(data.RoundsFileName, rounds)
+				sendOperatorAlert("Config reload failed, still using the current config", err)
+				continue
 			}
+			log.Println(result)
 		}
 	}()
 
@@ -177,44 +412,141 @@ func Start() error {
 	<-shutdownSignals
 	log.Println("shutdown signal received")
 	gracefulShutdown()
-	// TODO after moving main.go bot running logic here, add logic to remove registered commands on graceful shotdown
 	return nil
 }
 
-func reactionHandler(s *discordgo.Session, m *discordgo.MessageReactionAdd) {
-	if isBotOperator(m) && m.ChannelID == config.DefaultChannelID {
-		if _, ok := unprocessedMessages.M[m.MessageID]; ok {
+// openStores puts 'cfg' in use and loads the stored data into the globals.
+// With 'inMemory' the stored data is only read, changes are kept in memory
+// until the bot stops. Settings changed with /config are applied over 'cfg'
+// and the templates loaded
+func openStores(cfg *config.Config, inMemory bool) error {
+	currentConfig.Store(cfg)
+	storeInMemory = inMemory
+	var err error
+	store, err = openStorage()
+	if err != nil {
+		return err
+	}
+	if inMemory {
+		store = storage.NewOverlay(store)
+	}
+	// load stored data into maps and pass up to global scope
+	protocols = loadProtocols()
+	unprocessedMessages = loadUnprocessedMessages()
+	funds = loadFunds()
+	searchCache, searchUsage = loadSearchCache()
+	// settings changed with /config are kept in storage, apply them over the
+	// loaded config
+	effective, err := withSettings(cfg)
+	if err != nil {
+		return err
+	}
+	swapConfig(cfg, effective)
+	return loadTemplates(conf().TemplatesDir)
+}
+
+// newSession creates the discord session for config.Token and sets BotId.
+// The session isn't connected to the gateway. 's' is set to it, or to a
+// shadowSession wrapping it in shadow mode
+func newSession() (*discordgo.Session, error) {
+	session, err := discordgo.New("Bot " + conf().Token)
+	if err != nil {
+		return nil, err
+	}
+	s = session
+	if conf().ShadowMode != "" {
+		s, err = newShadowSession(session)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("shadow mode %s, changes aren't stored and nothing is posted where it normally goes\n", conf().ShadowMode)
+	}
+
+	u, err := session.User("@me")
+	if err != nil {
+		return nil, err
+	}
+	BotId = u.ID
+	return session, nil
+}
+
+// registerCommands replaces the bot's commands in config.GuildID with
+// 'commands', commands no longer in the list are removed
+func registerCommands(session *discordgo.Session) error {
+	_, err := session.ApplicationCommandBulkOverwrite(BotId, conf().GuildID, commands)
+	if err != nil {
+		return fmt.Errorf("registering commands in guild %s | %w", conf().GuildID, err)
+	}
+	return nil
+}
+
+func reactionHandler(s discordSession, m *discordgo.MessageReactionAdd) {
+	if reactionAllowed(s, m, config.CapResolveTwitter) {
+		// the message may be in the default channel, a round thread or a forum post
+		if unproMsg, ok := unprocessedMessages.M[m.MessageID]; ok && messageChannel(unproMsg) == m.ChannelID {
 			switch unprocessedMessages.M[m.MessageID].Type {
 			case data.RoundMsg:
 				switch m.MessageReaction.Emoji.Name {
-				case config.TwitterEmojiName:
-					s.MessageReactionsRemoveAll(config.DefaultChannelID, m.MessageID)
-					msg, err := s.ChannelMessage(config.DefaultChannelID, m.MessageID)
+				case conf().TwitterEmojiName:
+					// the embed title comes from a user editable template so use
+					// the protocol name stored with the message instead
+					name := unprocessedMessages.M[m.MessageID].ProtocolName
+					// google search for the twitter website of the name, results go
+					// to the protocol's thread if it has one
+					searchChannelID := protocolChannel(name, m.ChannelID)
+					resp, err := googleSearch(context.Background(), name, 1)
 					if err != nil {
-						//TODO: handle this error and prevent from reaching rest of code block
-						fmt.Println("error getting message |", err)
+						// leave the round message pending, only take back the operator's react
+						sendSearchErrorEmbed(s, searchChannelID, name, err)
+						s.MessageReactionRemove(m.ChannelID, m.MessageID, m.Emoji.APIName(), m.UserID)
+						return
 					}
-					for _, embed := range msg.Embeds { // there should only be one
-						name := embed.Title
-						// google search for the twitter website of the name
-						newMsg, urlEmbeds := googleSearchForName(s, name, 1)
-						unprocessedMessages.M[newMsg.ID] = data.UnprocessedMessage{
-							Type:         data.GoogleResult,
-							ProtocolName: unprocessedMessages.M[m.MessageID].ProtocolName,
-							Embeds:       *urlEmbeds,
-							Start:        1,
-							ParentMsgID:  m.MessageID,
-							New:          true,
-						}
 
-						// delete original rounds message from memory
-						if !unprocessedMessages.M[m.MessageID].New {
-							unprocessedMessages.Modified = true
+					// skip asking the operator to pick when a result clearly matches
+					if candidate, ok := bestCandidate(searchCandidates(resp, name, unproMsg.Symbol)); ok {
+						err = autoLinkTwitter(s, reactionAudit(m, "auto-link"), searchChannelID, name, candidate, unproMsg, m.MessageID)
+						if err == nil {
+							s.MessageReactionsRemoveAll(m.ChannelID, m.MessageID)
+							if !unproMsg.New {
+								unprocessedMessages.Modified = true
+							}
+							delete(unprocessedMessages.M, m.MessageID)
+							return
 						}
-						delete(unprocessedMessages.M, m.MessageID)
+						// nothing was linked, let the operator pick from the results
+						log.Println(err)
+					}
+
+					newMsg, urlEmbeds, urls, err := sendSearchResults(s, searchChannelID, name, resp)
+					if err != nil {
+						sendSearchErrorEmbed(s, searchChannelID, name, err)
+						s.MessageReactionRemove(m.ChannelID, m.MessageID, m.Emoji.APIName(), m.UserID)
+						return
+					}
+					s.MessageReactionsRemoveAll(m.ChannelID, m.MessageID)
+					entry := reactionAudit(m, "search-twitter")
+					entry.Protocol = name
+					audit(entry)
+					unprocessedMessages.M[newMsg.ID] = data.UnprocessedMessage{
+						Type:            data.GoogleResult,
+						ProtocolName:    name,
+						Symbol:          unproMsg.Symbol,
+						Embeds:          *urlEmbeds,
+						URLs:            urls,
+						ChannelID:       searchChannelID,
+						Start:           1,
+						ParentMsgID:     m.MessageID,
+						ParentChannelID: m.ChannelID,
+						New:             true,
+					}
 
-						sendGoogleSearchReacts(s, newMsg, 1)
+					// delete original rounds message from memory
+					if !unprocessedMessages.M[m.MessageID].New {
+						unprocessedMessages.Modified = true
 					}
+					delete(unprocessedMessages.M, m.MessageID)
+
+					sendGoogleSearchReacts(s, searchChannelID, newMsg, 1, len(urls))
 
 				default: // do nothing
 
@@ -223,22 +555,24 @@ func reactionHandler(s *discordgo.Session, m *discordgo.MessageReactionAdd) {
 				switch m.MessageReaction.Emoji.Name {
 				case "1️⃣", "2️⃣", "3️⃣":
 					// update url field for protocol in memory with twitter url stored in unprocessed messages embeds slice
-					var twitterUrl string
+					var idx int
 					switch m.MessageReaction.Emoji.Name {
 					case "1️⃣":
-						twitterUrl = unprocessedMessages.M[m.MessageID].Embeds[0].URL
+						idx = 0
 					case "2️⃣":
-						twitterUrl = unprocessedMessages.M[m.MessageID].Embeds[1].URL
+						idx = 1
 					case "3️⃣":
-						twitterUrl = unprocessedMessages.M[m.MessageID].Embeds[2].URL
+						idx = 2
+					}
+					twitterUrl, ok := unprocessedMessages.M[m.MessageID].ResultURL(idx)
+					if !ok {
+						// fewer results than reactions, nothing to pick
+						return
 					}
 					name := unprocessedMessages.M[m.MessageID].ProtocolName
-					tmpProtocol := protocols.M[name]
-					tmpProtocol.TwitterURL = twitterUrl
-					protocols.M[name] = tmpProtocol
-					protocols.Modified = true
-					s.MessageReactionsRemoveAll(config.DefaultChannelID, m.MessageID)
-					s.ChannelMessageDelete(config.DefaultChannelID, m.MessageID)
+					changeTwitterURL(reactionAudit(m, "pick-twitter"), name, twitterUrl)
+					s.MessageReactionsRemoveAll(m.ChannelID, m.MessageID)
+					s.ChannelMessageDelete(m.ChannelID, m.MessageID)
 					if !unprocessedMessages.M[m.MessageID].New {
 						unprocessedMessages.Modified = true
 					}
@@ -257,22 +591,25 @@ func reactionHandler(s *discordgo.Session, m *discordgo.MessageReactionAdd) {
 				case "❌":
 					// add parent message back to unprocessed messages map
 					ogID := unprocessedMessages.M[m.MessageID].ParentMsgID
-					oldMsg, err := s.ChannelMessage(config.DefaultChannelID, ogID)
+					ogChannelID := parentChannel(unprocessedMessages.M[m.MessageID])
+					oldMsg, err := s.ChannelMessage(ogChannelID, ogID)
 					if err != nil {
-						// TODO handle this error and prevent reaching delete from map and delete google search msg
-						// ChannelMessage has built-in retries, discordgo.ErrJSONUnmarshal is returned on any errors during unmarshalling
-						fmt.Println("error getting message |", err)
+						// keep the search results up, only take back the operator's react so
+						// they can try again
+						log.Printf("getting round message %s to cancel search | %v\n", ogID, err)
+						s.MessageReactionRemove(m.ChannelID, m.MessageID, m.Emoji.APIName(), m.UserID)
+						return
 					}
-					unprocessedMessages.M[ogID] = data.UnprocessedMessage{Type: data.RoundMsg, ProtocolName: unprocessedMessages.M[m.MessageID].ProtocolName, Embeds: oldMsg.Embeds}
+					unprocessedMessages.M[ogID] = data.UnprocessedMessage{Type: data.RoundMsg, ProtocolName: unprocessedMessages.M[m.MessageID].ProtocolName, Symbol: unprocessedMessages.M[m.MessageID].Symbol, Embeds: oldMsg.Embeds, ChannelID: ogChannelID}
 
 					// add twitter reaction back to parent message
-					err = s.MessageReactionAdd(config.DefaultChannelID, ogID, config.TwitterEmoji)
+					err = s.MessageReactionAdd(ogChannelID, ogID, conf().TwitterEmoji())
 					if err != nil {
 						log.Println("failed to add reaction back to message id ", ogID, " | ", err)
 					}
 
 					// delete google search result message
-					err = s.ChannelMessageDelete(config.DefaultChannelID, m.MessageID)
+					err = s.ChannelMessageDelete(m.ChannelID, m.MessageID)
 					if err != nil {
 						log.Println("deleting message |", err)
 					}
@@ -284,16 +621,23 @@ func reactionHandler(s *discordgo.Session, m *discordgo.MessageReactionAdd) {
 					}
 
 					delete(unprocessedMessages.M, m.MessageID)
+					entry := reactionAudit(m, "cancel-search")
+					entry.Protocol = unprocessedMessages.M[ogID].ProtocolName
+					audit(entry)
 
 				default: //do nothing
 
 				}
+			case data.AutoLinkNotice:
+				if m.MessageReaction.Emoji.Name == "❌" {
+					undoAutoLink(s, m)
+				}
 			}
 		}
 	}
 }
 
-func messageHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
+func messageHandler(s discordSession, m *discordgo.MessageCreate) {
 	if m.Author.ID == BotId {
 		return
 	}
@@ -302,7 +646,7 @@ func messageHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
 	switch {
 	case m.Content == "<@"+BotId+"> ping":
 		_, _ = s.ChannelMessageSend(m.ChannelID, m.Content)
-	case m.Content == config.BotPrefix+"ping":
+	case m.Content == conf().BotPrefix+"ping":
 		_, _ = s.ChannelMessageSend(m.ChannelID, "pong!")
 	}
 }
@@ -311,82 +655,117 @@ func messageHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
 // search the Google query. 'inc' should be -3 or 3 since this program searches
 // in threes, -3 is for previous page. It searches for the next page deleting
 // the old message from unprocessedMessages and adding the new search results
-// message to it
-func googleSearchNextPage(s *discordgo.Session, m *discordgo.MessageReactionAdd, inc int) {
+// message to it. If the search fails the old message is left as it was
+func googleSearchNextPage(s discordSession, m *discordgo.MessageReactionAdd, inc int) {
 	tmpUnproMsg := unprocessedMessages.M[m.MessageID]
 	tmpUnproMsg.Start += inc
-	newMsg, urlEmbeds := googleSearchForName(s, tmpUnproMsg.ProtocolName, tmpUnproMsg.Start)
+	newMsg, urlEmbeds, urls, err := googleSearchForName(s, m.ChannelID, tmpUnproMsg.ProtocolName, tmpUnproMsg.Start)
+	if err != nil {
+		sendSearchErrorEmbed(s, m.ChannelID, tmpUnproMsg.ProtocolName, err)
+		s.MessageReactionRemove(m.ChannelID, m.MessageID, m.Emoji.APIName(), m.UserID)
+		return
+	}
 	tmpUnproMsg.Embeds = *urlEmbeds
+	tmpUnproMsg.URLs = urls
 	tmpUnproMsg.Changed = true
-	s.ChannelMessageDelete(config.DefaultChannelID, m.MessageID)
+	s.ChannelMessageDelete(m.ChannelID, m.MessageID)
 	if !unprocessedMessages.M[m.MessageID].New {
 		unprocessedMessages.Modified = true
 	}
 	delete(unprocessedMessages.M, m.MessageID)
 	unprocessedMessages.M[newMsg.ID] = tmpUnproMsg
-	sendGoogleSearchReacts(s, newMsg, unprocessedMessages.M[newMsg.ID].Start)
+	sendGoogleSearchReacts(s, m.ChannelID, newMsg, tmpUnproMsg.Start, len(urls))
+	entry := reactionAudit(m, "search-page")
+	entry.Protocol, entry.Setting = tmpUnproMsg.ProtocolName, "start"
+	entry.Old, entry.New = auditValue(tmpUnproMsg.Start-inc), auditValue(tmpUnproMsg.Start)
+	audit(entry)
 }
 
 // sendGoogleSearchReacts is a helper function that sends predetermined reacts
-// to a google search message in the discord channel. It skips sending a left arrow
+// to a google search message in the channel 'channelID'. It sends a number
+// react for each of the 'results' on the page, skips sending a left arrow
 // if the 'start' of the query is at the first page and skips sending a right
 // arrow if the 'start' is at the last page
-func sendGoogleSearchReacts(s *discordgo.Session, msg *discordgo.Message, start int) {
-	s.MessageReactionAdd(config.DefaultChannelID, msg.ID, "1️⃣")
-	s.MessageReactionAdd(config.DefaultChannelID, msg.ID, "2️⃣")
-	s.MessageReactionAdd(config.DefaultChannelID, msg.ID, "3️⃣")
+func sendGoogleSearchReacts(s discordSession, channelID string, msg *discordgo.Message, start, results int) {
+	for _, emoji := range []string{"1️⃣", "2️⃣", "3️⃣"}[:min(results, 3)] {
+		s.MessageReactionAdd(channelID, msg.ID, emoji)
+	}
 	if start > 1 {
-		s.MessageReactionAdd(config.DefaultChannelID, msg.ID, "⬅️")
+		s.MessageReactionAdd(channelID, msg.ID, "⬅️")
 	}
 	if start < 97 {
-		s.MessageReactionAdd(config.DefaultChannelID, msg.ID, "➡️")
+		s.MessageReactionAdd(channelID, msg.ID, "➡️")
 	}
-	s.MessageReactionAdd(config.DefaultChannelID, msg.ID, "❌")
+	s.MessageReactionAdd(channelID, msg.ID, "❌")
 }
 
 // googleSearchForName sends a google search query for 'name' starting at query
-// number 'start'. it embeds the results and sends them to the discord session
-// default channel id then returns the pointer to the new embed message and
-// the slice of url embeds
-func googleSearchForName(s *discordgo.Session, name string, start int) (*discordgo.Message, *[]*discordgo.MessageEmbed) {
-	ctx := context.Background()
-	svc, err := customsearch.NewService(ctx, option.WithAPIKey(os.Getenv("GOOGLE_API_KEY")))
-	if err != nil {
-		log.Fatal("error creating custom search service |", err)
-	}
-	resp, err := svc.Cse.List().Cx(os.Getenv("GOOGLE_CX")).Q(name).Start(int64(start)).Num(3).OrTerms("web3").OrTerms("crypto").Do()
+// number 'start'. it embeds the results and sends them to the channel
+// 'channelID' then returns the pointer to the new embed message, the
+// slice of url embeds and the urls of the results in the same order. Returns
+// data.SearchQuotaError without searching while the daily quota is used up
+func googleSearchForName(s discordSession, channelID, name string, start int) (*discordgo.Message, *[]*discordgo.MessageEmbed, []string, error) {
+	resp, err := googleSearch(context.Background(), name, start)
 	if err != nil {
-		log.Fatal("error doing search |", err)
+		return nil, nil, nil, err
 	}
+	return sendSearchResults(s, channelID, name, resp)
+}
+
+// sendSearchResults embeds the results of a google search for 'name' and sends
+// them to the channel 'channelID' then returns the pointer to the new embed
+// message, the slice of url embeds and the urls of the results in the same
+// order. Result urls are normalized to twitter profiles, results that aren't on
+// twitter and repeats of the same profile are left out. A page without any
+// twitter profiles is sent as a single embed saying so and no urls
+func sendSearchResults(s discordSession, channelID, name string, resp *customsearch.Search) (*discordgo.Message, *[]*discordgo.MessageEmbed, []string, error) {
 	urlEmbeds := []*discordgo.MessageEmbed{}
-	for i, result := range resp.Items {
-		newEmbed := &discordgo.MessageEmbed{URL: result.FormattedUrl, Title: fmt.Sprintf("#%s %s", strconv.Itoa(i+1), result.Title), Description: result.Snippet}
+	urls := []string{}
+	for _, result := range resp.Items {
+		profileURL, _, ok := normalizeTwitterURL(result.FormattedUrl)
+		if !ok || containsString(urls, profileURL) {
+			continue
+		}
+		idx := len(urls) + 1
+		newEmbed, err := renderEmbed(searchTemplate, searchView{Index: idx, Title: result.Title, URL: profileURL, Snippet: result.Snippet, ProtocolName: name})
+		if err != nil {
+			log.Println(err)
+			newEmbed = &discordgo.MessageEmbed{URL: profileURL, Title: fmt.Sprintf("#%d %s", idx, result.Title), Description: result.Snippet}
+		}
 		urlEmbeds = append(urlEmbeds, newEmbed)
+		urls = append(urls, profileURL)
 	}
-	selectMsg, err := s.ChannelMessageSendEmbeds(config.DefaultChannelID, urlEmbeds)
-	if err != nil {
-		log.Fatal("error sending message |", err)
+	if len(urls) == 0 {
+		// keep a message up so the operator can still page past it or cancel
+		urlEmbeds = append(urlEmbeds, &discordgo.MessageEmbed{
+			Title:       "No twitter profiles on this page",
+			Description: fmt.Sprintf("None of these Google results for %s are twitter profiles, react ⬅️ or ➡️ to see other results or ❌ to cancel.", name),
+		})
 	}
-	return selectMsg, &urlEmbeds
-}
-
-// isBotOperator returns true if the member role for the person that sent this
-// message matches the BotOperatorRoleId stored in configs, otherwise returns false
-func isBotOperator(m *discordgo.MessageReactionAdd) bool {
-	for _, role := range m.Member.Roles {
-		if role == config.BotOperatorRoleID {
-			return true
-		}
+	selectMsg, err := s.ChannelMessageSendEmbeds(channelID, urlEmbeds)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("sending search results to channel %s | %w", channelID, discordError(err))
 	}
-	return false
+	return selectMsg, &urlEmbeds, urls, nil
 }
 
-// TODO add function description
-func sendIndividualFundingRoundsEmbeds(respDataStructs *[]data.RespData) map[string]data.Round {
+// sendIndividualFundingRoundsEmbeds sends an embed for each funding round
+// scoring at least the minimum individual score, tags the matching roles and
+// adds the twitter reaction for protocols without a stored twitter url. It
+// returns the posted rounds keyed by message id along with the errors of any
+// rounds that failed, a failed round doesn't stop the others being posted.
+// 'channelID' is passed on to postRound
+func sendIndividualFundingRoundsEmbeds(respDataStructs *[]data.RespData, channelID string) (map[string]data.Round, error) {
 	// loop over new funding rounds and send an embed to discord for each
 	rounds := make(map[string]data.Round, len(*respDataStructs))
+	var errs []error
 	for _, entry := range *respDataStructs {
-		time.Sleep(time.Millisecond * 400) // there seems to be some rate limiting for discord messages sent over the bot
+		recordFundRounds(entry)
+		score := scoreRound(entry)
+		if score < conf().Scoring.MinIndividualScore {
+			log.Printf("skipping embed for %s, score %.1f is below minimum %.1f\n", entry.Name, score, conf().Scoring.MinIndividualScore)
+			continue
+		}
 		desc := ""
 		// Put the coin ticker in the description if one exists
 		sym, ok := entry.Symbol.(string)
@@ -408,53 +787,36 @@ func sendIndividualFundingRoundsEmbeds(respDataStructs *[]data.RespData) map[str
 		tier2Joined := strings.Join(tier2, ", ")
 		raise := raiseToString(entry.Raise)
 		totalRaise := raiseToString(entry.TotalRaise)
-		newEmbed := &discordgo.MessageEmbed{
-			Title:       entry.Name,
-			Description: desc,
-			Color:       16753920,
-			Thumbnail: &discordgo.MessageEmbedThumbnail{
-				URL: entry.Icon,
-			},
-			Fields: []*discordgo.MessageEmbedField{
-				{Name: "Stage", Value: entry.Stage, Inline: true},
-				{Name: "Raise", Value: raise, Inline: true},
-				{Name: "Total Raise", Value: totalRaise, Inline: true},
-				{Name: "Category", Value: entry.Category.Name},
-				{Name: "Tier 1 Funds", Value: tier1Joined},
-				{Name: "Tier 2 Funds", Value: tier2Joined},
-			},
+		newEmbed, err := renderEmbed(roundTemplate, roundView{RespData: entry, Score: score})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rendering embed for %s | %w", entry.Name, err))
+			continue
 		}
 
-		discordMsg, err := s.ChannelMessageSendEmbed(config.DefaultChannelID, newEmbed)
+		time.Sleep(time.Millisecond * 400) // there seems to be some rate limiting for discord messages sent over the bot
+		var post roundPost
+		err = withRetry(func() error {
+			var err error
+			post, err = postRound(s, entry, newEmbed, channelID)
+			return err
+		})
 		if err != nil {
-			//TODO return err
-			log.Println(err)
+			errs = append(errs, fmt.Errorf("posting round for %s | %w", entry.Name, err))
 		} else {
-			newRef := &discordgo.MessageReference{
-				MessageID: discordMsg.ID,
-				ChannelID: config.DefaultChannelID,
-				GuildID:   config.GuildID,
-			}
-			// check for big names in tier 1 funds and tag roles
-			if len(tier1) > 0 {
-				for _, fundName := range tier1 {
-					if stringContainsCaseIns(fundName, "Binance") {
-						s.ChannelMessageSendReply(config.DefaultChannelID, config.BinanceRoundRoleID, newRef)
-					}
-					if stringContainsCaseIns(fundName, "Coinbase") {
-						s.ChannelMessageSendReply(config.DefaultChannelID, config.CoinbaseRoundRoleID, newRef)
-					}
-					if stringContainsCaseIns(fundName, "Paradigm") {
-						s.ChannelMessageSendReply(config.DefaultChannelID, config.ParadigmRoundRoleID, newRef)
-					}
+			// tag every role matching the round's funds or stage in one reply
+			ping := matchPingRules(entry)
+			if len(ping.RoleIDs) > 0 {
+				err = withRetry(func() error {
+					_, err := sendFollowUp(s, post, ping.message())
+					return discordSendError(err)
+				})
+				if err != nil {
+					errs = append(errs, fmt.Errorf("tagging roles for %s | %w", entry.Name, err))
 				}
 			}
-			// tag early role if round is seed/pre-seed/extended seed stage
-			if stringContainsCaseIns(entry.Stage, "Seed") {
-				s.ChannelMessageSendReply(config.DefaultChannelID, config.EarlyRoundRoleID, newRef)
-			}
 			newRound := data.Round{
 				Name:       entry.Name,
+				Date:       entry.Date.Format("2006-01-02"),
 				Desc:       desc,
 				Stage:      entry.Stage,
 				Raise:      raise,
@@ -462,125 +824,209 @@ func sendIndividualFundingRoundsEmbeds(respDataStructs *[]data.RespData) map[str
 				Category:   entry.Category.Name,
 				Tier1Funds: tier1Joined,
 				Tier2Funds: tier2Joined,
+				Score:      score,
 			}
-			rounds[newRef.MessageID] = newRound
+			rounds[post.MessageID] = newRound
 			if _, ok := protocols.M[entry.Name]; !ok {
-				//TODO after this logic moved to bot package, add new protocol names to command Choices and re-sort
 				newProtocol := data.Protocol{Name: entry.Name, New: true}
 				protocols.M[entry.Name] = newProtocol
 				protocols.Appended = true
 			}
+			query := newHandleQuery(entry)
+			fillProtocolLinks(context.Background(), query)
 			if protocols.M[entry.Name].TwitterURL == "" {
-				unprocessedMessages.M[newRef.MessageID] = data.UnprocessedMessage{Type: data.RoundMsg, ProtocolName: entry.Name, Embeds: []*discordgo.MessageEmbed{newEmbed}, New: true}
-				err = s.MessageReactionAdd(config.DefaultChannelID, newRef.MessageID, config.TwitterEmoji)
+				roundMsg := data.UnprocessedMessage{Type: data.RoundMsg, ProtocolName: entry.Name, Symbol: desc, Embeds: []*discordgo.MessageEmbed{newEmbed}, ChannelID: post.ChannelID, New: true}
+				// only ask an operator to pick the twitter when the resolvers aren't sure
+				if candidate, ok := resolveHandle(context.Background(), query); ok {
+					log.Printf("resolved twitter for %s to %s from %s with confidence %.2f\n", entry.Name, candidate.URL, candidate.Source, candidate.Confidence)
+					err = autoLinkTwitter(s, botAudit("auto-link"), post.followUpChannel(), entry.Name, candidate, roundMsg, post.MessageID)
+					if err == nil {
+						continue
+					}
+					// nothing was linked, leave the round for an operator
+					log.Printf("auto-linking twitter for %s | %v\n", entry.Name, err)
+				}
+				unprocessedMessages.M[post.MessageID] = roundMsg
+				err = withRetry(func() error {
+					return discordError(s.MessageReactionAdd(post.ChannelID, post.MessageID, conf().TwitterEmoji()))
+				})
 				if err != nil {
-					//TODO return err
-					log.Println(err)
+					errs = append(errs, fmt.Errorf("adding twitter reaction for %s | %w", entry.Name, err))
 				}
 			}
 		}
 	}
-	return rounds
+	return rounds, errors.Join(errs...)
 }
 
-// sendFundingRoundsRecapEmbed sends the message of all funding rounds from today as an embed to discord
-func sendFundingRoundsRecapEmbed(respDataStructs *[]data.RespData, start string) error {
-	// builds a slice of fields for the embed, a funding round in each field
-	fields := make([]*discordgo.MessageEmbedField, len(*respDataStructs))
-	for i, data := range *respDataStructs {
-		fields[i] = &discordgo.MessageEmbedField{}
-		fields[i].Name = data.Name
-		sym, ok := data.Symbol.(string)
-		if ok {
-			fields[i].Name += " - " + sym
-		}
-		fields[i].Value = fmt.Sprintf("Raise: %s | Stage: %s | Category: %s", raiseToString(data.Raise), data.Stage, data.Category.Name)
+// recapEmbed builds the embed listing all funding rounds from 'start' to 'end'
+func recapEmbed(respDataStructs *[]data.RespData, start, end string) (*discordgo.MessageEmbed, error) {
+	// builds the recap template data, a funding round for each field
+	view := recapView{Date: start, End: end, Rounds: make([]roundView, len(*respDataStructs))}
+	for i, entry := range *respDataStructs {
+		view.Rounds[i] = roundView{RespData: entry, Score: scoreRound(entry)}
 	}
-	var empty bool
-	descr := "All rounds"
-	if len(fields) == 0 {
-		empty = true
-		descr = "None"
+	return renderEmbed(recapTemplate, view)
+}
+
+// sendFundingRoundsRecapEmbed sends the message of all funding rounds from 'start' to 'end' as an embed to discord.
+// It's sent to 'channelID', or config.DefaultChannelID when empty
+func sendFundingRoundsRecapEmbed(respDataStructs *[]data.RespData, start, end, channelID string) error {
+	if channelID == "" {
+		channelID = conf().DefaultChannelID
 	}
+	empty := len(*respDataStructs) == 0
 	// build and send an embed with a list of all funding rounds
-	allRoundsEmbed := &discordgo.MessageEmbed{
-		Title:       "Yesterday's Funding Rounds",
-		Description: descr,
-		Timestamp:   start,
-		Color:       8421504,
-		Fields:      fields,
-		Footer:      &discordgo.MessageEmbedFooter{},
+	allRoundsEmbed, err := recapEmbed(respDataStructs, start, end)
+	if err != nil {
+		return err
 	}
-	discordMsg, err := s.ChannelMessageSendEmbed(config.DefaultChannelID, allRoundsEmbed)
+	var discordMsg *discordgo.Message
+	err = withRetry(func() error {
+		var err error
+		discordMsg, err = s.ChannelMessageSendEmbed(channelID, allRoundsEmbed)
+		return discordSendError(err)
+	})
 	if err != nil {
-		return fmt.Errorf("sending embed to channel %s | %w", config.DefaultChannelID, err)
+		return fmt.Errorf("sending embed to channel %s | %w", channelID, err)
 	}
 	newRef := &discordgo.MessageReference{
 		MessageID: discordMsg.ID,
-		ChannelID: config.DefaultChannelID,
-		GuildID:   config.GuildID,
+		ChannelID: channelID,
+		GuildID:   conf().GuildID,
 	}
 
 	// ping funding rounds role id if there were any funding rounds today
 	if !empty {
-		_, err = s.ChannelMessageSendReply(config.DefaultChannelID, config.FundingRoundRoleID, newRef)
+		err = withRetry(func() error {
+			_, err := s.ChannelMessageSendReply(channelID, config.RoleMention(conf().FundingRoundRoleID), newRef)
+			return discordSendError(err)
+		})
 		if err != nil {
-			return fmt.Errorf("sending message repply (tag funding round role) to channel %s | %w", config.DefaultChannelID, err)
+			return fmt.Errorf("sending message repply (tag funding round role) to channel %s | %w", channelID, err)
 		}
 	}
 
 	return nil
 }
 
-// queryCryptoRank sends an http POST request to cryptorank's API for the previous day's funding rounds
-func queryCryptoRank(start string) *[]data.RespData {
-	// query the cryptorank.io api for funding rounds within one day
-	end := time.Now().Format("2006-01-02")
-	body := []byte(`{"limit":20,"filters":{"date":{"start":"` + start + `","end":"` + end + `"}},"skip":0,"sortingColumn":"date","sortingDirection":"DESC"}`)
-	//TODO error handling throughout; do something besides panic
+// cryptoRankPageSize is how many funding rounds are asked for per request,
+// queryCryptoRank pages through the rest
+const cryptoRankPageSize = 20
+
+// maxCryptoRankPages stops queryCryptoRank paging forever if cryptorank keeps
+// sending full pages
+const maxCryptoRankPages = 50
+
+// queryCryptoRank sends http POST requests to cryptorank's API for the
+// funding rounds dated from 'start' to 'end' (2006-01-02 format), paging until
+// a page comes back short. The pages are archived together as one response.
+// Network errors, rate limits and server errors are returned as temporary
+// errors
+func queryCryptoRank(start, end string) (*[]data.RespData, error) {
+	// rounds are kept raw so the archived response has every field cryptorank sent
+	var page struct {
+		Total int               `json:"total"`
+		Data  []json.RawMessage `json:"data"`
+	}
+	var rounds []json.RawMessage
+	for skip := 0; skip < maxCryptoRankPages*cryptoRankPageSize; skip += cryptoRankPageSize {
+		msg, err := queryCryptoRankPage(start, end, skip)
+		if err != nil {
+			return nil, err
+		}
+		page.Data = nil
+		err = json.Unmarshal(msg, &page)
+		if err != nil {
+			return nil, data.JsonMarshalError{OriginalErr: err}
+		}
+		rounds = append(rounds, page.Data...)
+		if len(page.Data) < cryptoRankPageSize || (page.Total > 0 && len(rounds) >= page.Total) {
+			break
+		}
+	}
+	page.Data = rounds
+	if page.Data == nil {
+		page.Data = []json.RawMessage{}
+	}
+	msg, err := json.Marshal(page)
+	if err != nil {
+		return nil, data.JsonMarshalError{OriginalErr: err}
+	}
+	archiveResponse(start, end, msg)
+	return decodeCryptoRank(msg)
+}
+
+// queryCryptoRankPage requests the page of funding rounds dated from 'start'
+// to 'end' after skipping the first 'skip' rounds and returns the raw response
+func queryCryptoRankPage(start, end string, skip int) ([]byte, error) {
+	body := []byte(fmt.Sprintf(`{"limit":%d,"filters":{"date":{"start":%q,"end":%q}},"skip":%d,"sortingColumn":"date","sortingDirection":"DESC"}`, cryptoRankPageSize, start, end, skip))
 	r, err := http.NewRequest("POST", data.PostURL, bytes.NewBuffer(body))
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("building cryptorank request | %w", err)
 	}
 	r.Header.Add("Content-Type", "application/json")
-	client := &http.Client{}
+	client := &http.Client{Timeout: data.RequestTimeout}
 	res, err := client.Do(r)
 	if err != nil {
-		panic(err)
+		return nil, data.HTTPRequestError{URL: data.PostURL, OriginalErr: err}
 	}
+	defer res.Body.Close()
 	msg, err := io.ReadAll(res.Body)
 	if err != nil {
-		panic(err)
+		return nil, data.HTTPRequestError{URL: data.PostURL, OriginalErr: err}
 	}
-	var resp data.Resp
-	err = json.Unmarshal(msg, &resp)
-	if err != nil {
-		panic(data.JsonMarshalError{OriginalErr: err})
+	if res.StatusCode != http.StatusOK {
+		return nil, data.HTTPStatusError{URL: data.PostURL, StatusCode: res.StatusCode, Body: truncate(string(msg), 200)}
 	}
-	err = res.Body.Close()
+	return msg, nil
+}
+
+// decodeCryptoRank decodes the funding rounds response 'msg' from cryptorank,
+// the rounds are sorted by score
+func decodeCryptoRank(msg []byte) (*[]data.RespData, error) {
+	var resp data.Resp
+	err := json.Unmarshal(msg, &resp)
 	if err != nil {
-		panic(err)
+		return nil, data.JsonMarshalError{OriginalErr: err}
 	}
 	respDataStructs := resp.Data
-	sort.Slice(respDataStructs, func(i, j int) bool {
-		return respDataStructs[i].Raise > respDataStructs[j].Raise
-	})
+	sortRoundsByScore(respDataStructs)
+
+	return &respDataStructs, nil
+}
+
+// discordError wraps a non nil error from a discord request in a
+// data.DiscordError so temporary failures can be retried
+func discordError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return data.DiscordError{OriginalErr: err}
+}
 
-	return &respDataStructs
+// discordSendError wraps a non nil error from a discord request that posts
+// something in a data.DiscordSendError, so it's only retried when nothing
+// can have been posted
+func discordSendError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return data.DiscordSendError{OriginalErr: err}
 }
 
-// gracefulShutdown backs up both jsonl files for protocols and unprocessed
-// messages to disk. It checks if the struct in memory has been modified (a field
-// has been changed or a key deleted) or appended (no fields changed only new
-// key added). If it has been modified it overwrites the file, if it has been
-// appended it appends only the new data to the file. Otherwise it leaves the
-// file alone. It logs any errors encountered.
+// gracefulShutdown saves protocols, unprocessed messages, funds and the search
+// cache to storage and closes it. It checks if the struct in memory has been
+// modified (a field has been changed or a key deleted) or appended (no fields
+// changed only new key added). If it has been modified it replaces the whole
+// bucket, if it has been appended it only puts the new data. Otherwise it
+// leaves the bucket alone. It logs any errors encountered.
 func gracefulShutdown() {
 	// backup unprocessed messages
 	if unprocessedMessages.IsModified() {
-		err := OverwriteFile(data.UnprocessedMessagesFileName, unprocessedMessages.M)
+		err := saveBucket(storage.UnprocessedMessagesBucket, unprocessedMessages.M, true)
 		if err != nil {
-			log.Printf("overwriting file %s | %v\n", data.UnprocessedMessagesFileName, err)
+			log.Printf("saving bucket %s | %v\n", storage.UnprocessedMessagesBucket, err)
 		}
 	} else if unprocessedMessages.IsAppended() {
 		// build map of only new messages to append
@@ -590,17 +1036,17 @@ func gracefulShutdown() {
 				newMessages[name] = msg
 			}
 		}
-		err := AppendToFile(data.UnprocessedMessagesFileName, newMessages)
+		err := saveBucket(storage.UnprocessedMessagesBucket, newMessages, false)
 		if err != nil {
-			log.Printf("appending to file %s | %v\n", data.UnprocessedMessagesFileName, err)
+			log.Printf("saving bucket %s | %v\n", storage.UnprocessedMessagesBucket, err)
 		}
 	}
 
 	// backup protocols
 	if protocols.Modified {
-		err := OverwriteFile(data.ProtocolsFileName, protocols.M)
+		err := saveBucket(storage.ProtocolsBucket, protocols.M, true)
 		if err != nil {
-			log.Printf("overwriting file %s | %v\n", data.ProtocolsFileName, err)
+			log.Printf("saving bucket %s | %v\n", storage.ProtocolsBucket, err)
 		}
 	} else if protocols.Appended {
 		// build map of only new protocols to append
@@ -610,9 +1056,42 @@ func gracefulShutdown() {
 				newProtocols[name] = protocol
 			}
 		}
-		err := AppendToFile(data.ProtocolsFileName, newProtocols)
+		err := saveBucket(storage.ProtocolsBucket, newProtocols, false)
+		if err != nil {
+			log.Printf("saving bucket %s | %v\n", storage.ProtocolsBucket, err)
+		}
+	}
+
+	// backup funds, rounds are added to existing funds so any change needs an overwrite
+	if funds.Modified {
+		err := saveBucket(storage.FundsBucket, funds.M, true)
+		if err != nil {
+			log.Printf("saving bucket %s | %v\n", storage.FundsBucket, err)
+		}
+	}
+
+	// backup search cache and quota usage so restarts don't spend quota again
+	if searchCache.Modified {
+		err := saveBucket(storage.SearchCacheBucket, searchCache.M, true)
+		if err != nil {
+			log.Printf("saving bucket %s | %v\n", storage.SearchCacheBucket, err)
+		}
+	}
+	if searchUsage.Modified {
+		err := saveBucket(storage.SearchUsageBucket, searchUsage.M, true)
+		if err != nil {
+			log.Printf("saving bucket %s | %v\n", storage.SearchUsageBucket, err)
+		}
+	}
+
+	err := store.Close()
+	if err != nil {
+		log.Println("closing storage |", err)
+	}
+	if shadow, ok := s.(*shadowSession); ok && shadow.log != nil {
+		err = shadow.log.Close()
 		if err != nil {
-			log.Printf("appending to file %s | %v\n", data.ProtocolsFileName, err)
+			log.Println("closing shadow log |", err)
 		}
 	}
 }
@@ -668,6 +1147,22 @@ func tryAppendFile[T any](fileName string, dataIn map[string]T) error {
 	// create new writer for file
 	writer := bufio.NewWriter(file)
 
+	// start new files with the schema header
+	info, err := file.Stat()
+	if err != nil {
+		return data.ReadWriteFileError{OriginalErr: err}
+	}
+	if info.Size() == 0 {
+		header, err := schemaHeader(fileName)
+		if err != nil {
+			return err
+		}
+		_, err = writer.WriteString(header)
+		if err != nil {
+			return data.ReadWriteFileError{OriginalErr: err}
+		}
+	}
+
 	// write incoming data to file
 	for k, v := range dataIn {
 		// build a new map to preserve the key when writing entries on
@@ -690,6 +1185,23 @@ func tryAppendFile[T any](fileName string, dataIn map[string]T) error {
 	return err
 }
 
+// writeJsonlFile overwrites the store file 'fileName' with 'records'. An
+// empty map leaves just the schema header where OverwriteFile would skip it
+func writeJsonlFile(fileName string, records map[string]json.RawMessage) error {
+	if len(records) > 0 {
+		return OverwriteFile(fileName, records)
+	}
+	header, err := schemaHeader(fileName)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(fileName, []byte(header), 0666)
+	if err != nil {
+		return data.ReadWriteFileError{OriginalErr: err}
+	}
+	return nil
+}
+
 // OverwriteFile works with any map[string]type. It attempts overwriting the file
 // at path 'fileName' with the map passed to 'dataIn'. If any 'temporary' type
 // errors are encountered, it retries overwriting up to 'MaxOverwriteAttempts'
@@ -729,8 +1241,8 @@ func OverwriteFile[T any](fileName string, dataIn map[string]T) error {
 // file. Any errors encountered except for during json marshalling are returned
 // as a 'temporary' type error.
 func tryOverwriteFile[T any](fileName string, dataIn map[string]T) (err error) {
-	tmpFileName := "tmp_" + fileName
-	tmp, err := os.OpenFile(tmpFileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	tmpFileName := tmpFileName(fileName)
+	tmp, err := os.OpenFile(tmpFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
 	if err != nil {
 		return data.ReadWriteFileError{OriginalErr: err}
 	}
@@ -751,8 +1263,16 @@ func tryOverwriteFile[T any](fileName string, dataIn map[string]T) (err error) {
 		}
 	}()
 
-	// write data to jsonl file line by line
+	// write data to jsonl file line by line after the schema header
 	writer := bufio.NewWriter(tmp)
+	header, err := schemaHeader(fileName)
+	if err != nil {
+		return err
+	}
+	_, err = writer.WriteString(header)
+	if err != nil {
+		return data.ReadWriteFileError{OriginalErr: err}
+	}
 	for k, v := range dataIn {
 		bufMap := map[string]T{k: v}
 		jsonNewP, err := json.Marshal(bufMap)
@@ -794,29 +1314,9 @@ func tryOverwriteFile[T any](fileName string, dataIn map[string]T) (err error) {
 // parsing the data into a map[string]data.UnprocessedMessage struct and returns it
 // it shuts down the program if any errors encountered
 func loadUnprocessedMessages() *data.UnprocessedMessages {
-	//TODO do something besides quit on errors, implement retries? check for backup file if data is corrupted?
-	unprocessedMessagesFile, err := os.OpenFile(data.UnprocessedMessagesFileName, os.O_CREATE|os.O_RDONLY, 0666)
-	if err != nil {
-		log.Fatal("error opening unprocessedMessagesFile")
-	}
-	upm := map[string]data.UnprocessedMessage{}
-	unpMsgsDecoder := json.NewDecoder(unprocessedMessagesFile)
-	for {
-		unprocessedMessageId := map[string]data.UnprocessedMessage{}
-		err = unpMsgsDecoder.Decode(&unprocessedMessageId)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			log.Fatal("error decoding message |", err)
-		}
-		for k, v := range unprocessedMessageId {
-			upm[k] = v
-		}
-	}
-	err = unprocessedMessagesFile.Close()
+	upm, err := loadBucket[data.UnprocessedMessage](storage.UnprocessedMessagesBucket)
 	if err != nil {
-		log.Fatal("error closing unprocessedMessagesFile")
+		log.Fatal("error loading unprocessed messages file |", err)
 	}
 	ret := &data.UnprocessedMessages{
 		M:        upm,
@@ -829,33 +1329,166 @@ func loadUnprocessedMessages() *data.UnprocessedMessages {
 // memory. it creates a new data.Protocols struct and assigns the data into
 // its map field. It shuts down the program if any errors
 func loadProtocols() *data.Protocols {
-	//TODO do something besides quit on errors, implement retries? check for backup file if data is corrupted?
-	protocolsFile, err := os.OpenFile(data.ProtocolsFileName, os.O_CREATE|os.O_RDONLY, 0666)
+	protocols, err := loadBucket[data.Protocol](storage.ProtocolsBucket)
+	if err != nil {
+		log.Fatal("error loading protocols file |", err)
+	}
+	p := &data.Protocols{M: protocols, Modified: false}
+	return p
+}
+
+// loadFunds reads the stored funds and parses the data into memory. It
+// creates a new data.Funds struct and assigns the data into its map field. It
+// shuts down the program if any errors
+func loadFunds() *data.Funds {
+	funds, err := loadBucket[data.Fund](storage.FundsBucket)
+	if err != nil {
+		log.Fatal("error loading funds file |", err)
+	}
+	return &data.Funds{M: funds, Modified: false}
+}
+
+// loadSearchCache reads the search cache and search usage files into memory.
+// The cache can always be rebuilt by searching again so errors are logged and
+// the bot starts with an empty cache
+func loadSearchCache() (*data.SearchCache, *data.SearchUsage) {
+	cache, err := loadBucket[data.CachedSearch](storage.SearchCacheBucket)
 	if err != nil {
-		log.Fatal("error opening protocols file |", err)
+		log.Println("error loading search cache file, starting with an empty cache |", err)
+		cache = map[string]data.CachedSearch{}
 	}
-	protocols := map[string]data.Protocol{}
-	protocolsDecoder := json.NewDecoder(protocolsFile)
-	for {
-		protocol := map[string]data.Protocol{}
-		err = protocolsDecoder.Decode(&protocol)
+	usage, err := loadBucket[int](storage.SearchUsageBucket)
+	if err != nil {
+		log.Println("error loading search usage file, starting with no searches counted |", err)
+		usage = map[string]int{}
+	}
+	return &data.SearchCache{M: cache}, &data.SearchUsage{M: usage}
+}
+
+// openStorage opens the storage backend set by config.Storage in
+// config.DataDir, the .jsonl files unless it's "bolt"
+func openStorage() (storage.Storage, error) {
+	if !storeInMemory {
+		err := os.MkdirAll(conf().DataDir, 0777)
 		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				log.Fatal("error decoding protocols file |", err)
-			}
+			return nil, data.ReadWriteFileError{OriginalErr: err}
 		}
-		for k, v := range protocol {
-			protocols[k] = v
+	}
+	switch conf().Storage {
+	case "bolt":
+		return openBolt()
+	case "", "jsonl":
+		return newJsonlStorage(conf().DataDir), nil
+	default:
+		return nil, fmt.Errorf("unknown storage %q, must be jsonl or bolt", conf().Storage)
+	}
+}
+
+// openBolt opens the bolt database at databasePath, migrating buckets stored
+// with an older schema version through the same migrations as their .jsonl
+// files. While storeInMemory is set the database is opened read only and
+// isn't migrated
+func openBolt() (*storage.BoltStorage, error) {
+	versions := make(map[string]int, len(storage.BucketFiles))
+	for bucket, fileName := range storage.BucketFiles {
+		versions[bucket] = schemaVersion(fileName)
+	}
+	if storeInMemory {
+		return storage.OpenBoltReadOnly(databasePath(), versions)
+	}
+	return storage.OpenBolt(databasePath(), versions, func(bucket string, from int, records map[string]json.RawMessage) error {
+		err := migrateRecords(storage.BucketFiles[bucket], from, records)
+		if err == nil {
+			log.Printf("migrated bucket %s in %s from schema version %d to %d\n", bucket, databasePath(), from, versions[bucket])
 		}
+		return err
+	})
+}
+
+// databasePath returns the path of the bolt database, a relative
+// config.DatabaseFile is inside config.DataDir
+func databasePath() string {
+	if filepath.IsAbs(conf().DatabaseFile) {
+		return conf().DatabaseFile
 	}
-	err = protocolsFile.Close()
+	return filepath.Join(conf().DataDir, conf().DatabaseFile)
+}
+
+// tmpFileName returns the name of the temporary file 'fileName' is written
+// to before it's renamed over the original
+func tmpFileName(fileName string) string {
+	return filepath.Join(filepath.Dir(fileName), "tmp_"+filepath.Base(fileName))
+}
+
+// loadBucket reads every record of 'bucket' from storage into a map
+func loadBucket[T any](bucket string) (map[string]T, error) {
+	dataOut := map[string]T{}
+	err := store.View(func(tx storage.Tx) error {
+		return tx.ForEach(bucket, func(key string, raw json.RawMessage) error {
+			var v T
+			err := json.Unmarshal(raw, &v)
+			if err != nil {
+				return data.JsonMarshalError{OriginalErr: fmt.Errorf("key %s | %w", key, err)}
+			}
+			dataOut[key] = v
+			return nil
+		})
+	})
+	return dataOut, err
+}
+
+// saveBucket puts every record of 'dataIn' into 'bucket' in one transaction.
+// If 'replace' is true records in the bucket missing from 'dataIn' are deleted
+func saveBucket[T any](bucket string, dataIn map[string]T, replace bool) error {
+	return store.Update(func(tx storage.Tx) error {
+		if replace {
+			var stale []string
+			err := tx.ForEach(bucket, func(key string, raw json.RawMessage) error {
+				if _, ok := dataIn[key]; !ok {
+					stale = append(stale, key)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, key := range stale {
+				err = tx.Delete(bucket, key)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for key, v := range dataIn {
+			err := tx.Put(bucket, key, v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// loadJsonlFile reads the .jsonl file at 'fileName', creating it if it doesn't
+// exist unless storeInMemory is set, and merges each line's key value pairs into a single map. Later lines
+// overwrite earlier ones with the same key. Leftover backup files are merged
+// in, malformed lines are quarantined and older schema versions migrated, see
+// recoverJsonlRecords
+func loadJsonlFile[T any](fileName string) (map[string]T, error) {
+	records, err := recoverJsonlRecords(fileName)
 	if err != nil {
-		log.Fatal("error closing protocols file |", err)
+		return nil, err
 	}
-	p := &data.Protocols{M: protocols, Modified: false}
-	return p
+	dataOut := make(map[string]T, len(records))
+	for k, raw := range records {
+		var v T
+		err = json.Unmarshal(raw, &v)
+		if err != nil {
+			return nil, data.JsonMarshalError{OriginalErr: fmt.Errorf("key %s | %w", k, err)}
+		}
+		dataOut[k] = v
+	}
+	return dataOut, nil
 }
 
 // stringContainsCaseIns is a helper function to compare a string s and substring
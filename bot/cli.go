@@ -0,0 +1,517 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/readysetliqd/airdrop-discord-bot-go/config"
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+	"github.com/readysetliqd/airdrop-discord-bot-go/storage"
+)
+
+// The command line subcommands in this file work on the stored data without
+// connecting to discord, except Backfill and the command registration. They
+// should run while the bot is stopped, a running bot keeps its own copy of the
+// data in memory and saves it over theirs on shutdown
+
+// bucketRecords returns a new record of the type stored in each bucket, used
+// to check imported records
+var bucketRecords = map[string]func() any{
+	storage.ProtocolsBucket:           func() any { return &data.Protocol{} },
+	storage.UnprocessedMessagesBucket: func() any { return &data.UnprocessedMessage{} },
+	storage.RoundsBucket:              func() any { return &data.Round{} },
+	storage.FundsBucket:               func() any { return &data.Fund{} },
+	storage.SearchCacheBucket:         func() any { return &data.CachedSearch{} },
+	storage.SearchUsageBucket:         func() any { return new(int) },
+	storage.SettingsBucket:            func() any { return new(json.RawMessage) },
+	storage.AuditBucket:               func() any { return &data.AuditEntry{} },
+}
+
+// csvValueColumn is the CSV column of records that aren't json objects
+const csvValueColumn = "value"
+
+// bucketNames returns every bucket name sorted
+func bucketNames() []string {
+	names := make([]string, 0, len(storage.BucketFiles))
+	for bucket := range storage.BucketFiles {
+		names = append(names, bucket)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkBucket returns an error naming the buckets if 'bucket' isn't one
+func checkBucket(bucket string) error {
+	if _, ok := storage.BucketFiles[bucket]; !ok {
+		return fmt.Errorf("unknown bucket %q, must be one of %s", bucket, strings.Join(bucketNames(), ", "))
+	}
+	return nil
+}
+
+// isCSV returns true if 'fileName' is read and written as CSV instead of json
+func isCSV(fileName string) bool {
+	return strings.EqualFold(filepath.Ext(fileName), ".csv")
+}
+
+// Export writes every record of 'bucket' to 'fileName', as CSV if it ends in
+// .csv and as a json object by key otherwise. An empty 'fileName' writes json
+// to stdout
+func Export(cfg *config.Config, bucket, fileName string) error {
+	err := checkBucket(bucket)
+	if err != nil {
+		return err
+	}
+	currentConfig.Store(cfg)
+	store, err = openStorage()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	records, err := loadBucket[json.RawMessage](bucket)
+	if err != nil {
+		return fmt.Errorf("reading bucket %s | %w", bucket, err)
+	}
+
+	var out bytes.Buffer
+	if isCSV(fileName) {
+		err = writeRecordsCSV(&out, bucket, records)
+	} else {
+		var raw []byte
+		raw, err = json.MarshalIndent(records, "", "  ")
+		out.Write(append(raw, '\n'))
+	}
+	if err != nil {
+		return fmt.Errorf("encoding bucket %s | %w", bucket, err)
+	}
+	if fileName == "" {
+		_, err = os.Stdout.Write(out.Bytes())
+		return err
+	}
+	err = os.WriteFile(fileName, out.Bytes(), 0666)
+	if err != nil {
+		return data.ReadWriteFileError{OriginalErr: err}
+	}
+	fmt.Fprintf(os.Stderr, "exported %d records from %s to %s\n", len(records), bucket, fileName)
+	return nil
+}
+
+// recordFields returns the kind of each json field of the records stored in
+// 'bucket' by json name, or nil if they aren't json objects
+func recordFields(bucket string) map[string]reflect.Kind {
+	t := reflect.TypeOf(bucketRecords[bucket]()).Elem()
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	kinds := map[string]reflect.Kind{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		kinds[name] = field.Type.Kind()
+	}
+	return kinds
+}
+
+// writeRecordsCSV writes the 'records' of 'bucket' to 'w' as CSV with a row
+// per record sorted by key. Records that are json objects get a column for
+// each field, other records a value column. Strings are written as is, other
+// values as json. Settings can be any json so they're always written as json
+func writeRecordsCSV(w io.Writer, bucket string, records map[string]json.RawMessage) error {
+	object := recordFields(bucket) != nil
+	rawJSON := bucket == storage.SettingsBucket
+	keys := make([]string, 0, len(records))
+	rows := make(map[string]map[string]json.RawMessage, len(records))
+	columnSet := map[string]bool{}
+	if !object {
+		columnSet[csvValueColumn] = true
+	}
+	for key, raw := range records {
+		keys = append(keys, key)
+		fields := map[string]json.RawMessage{csvValueColumn: raw}
+		if object {
+			fields = map[string]json.RawMessage{}
+			err := json.Unmarshal(raw, &fields)
+			if err != nil {
+				return fmt.Errorf("record %s | %w", key, data.JsonMarshalError{OriginalErr: err})
+			}
+		}
+		for column := range fields {
+			columnSet[column] = true
+		}
+		rows[key] = fields
+	}
+	sort.Strings(keys)
+	columns := make([]string, 0, len(columnSet))
+	for column := range columnSet {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	writer := csv.NewWriter(w)
+	err := writer.Write(append([]string{"key"}, columns...))
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		row := []string{key}
+		for _, column := range columns {
+			if rawJSON {
+				row = append(row, string(rows[key][column]))
+				continue
+			}
+			row = append(row, csvCell(rows[key][column]))
+		}
+		err = writer.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvCell returns the json value 'raw' as a CSV cell, strings unquoted and
+// null or missing values empty
+func csvCell(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+	return string(raw)
+}
+
+// csvValue returns the CSV cell 'cell' as json. Cells of string fields are
+// strings, other cells are json or taken as strings when they aren't valid
+// json
+func csvValue(cell string, kind reflect.Kind) json.RawMessage {
+	if kind != reflect.String && json.Valid([]byte(cell)) {
+		return json.RawMessage(cell)
+	}
+	raw, _ := json.Marshal(cell)
+	return raw
+}
+
+// readRecordsCSV reads the CSV written by writeRecordsCSV back into json
+// records of 'bucket'. Empty cells are left out
+func readRecordsCSV(r io.Reader, bucket string) (map[string]json.RawMessage, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 || len(rows[0]) == 0 || rows[0][0] != "key" {
+		return nil, errors.New("the first row must be the column names starting with key")
+	}
+	columns := rows[0]
+	kinds := recordFields(bucket)
+	if kinds == nil {
+		if len(columns) != 2 || columns[1] != csvValueColumn {
+			return nil, fmt.Errorf("bucket %s needs the columns key and %s", bucket, csvValueColumn)
+		}
+		kind := reflect.TypeOf(bucketRecords[bucket]()).Elem().Kind()
+		records := make(map[string]json.RawMessage, len(rows)-1)
+		for _, row := range rows[1:] {
+			records[row[0]] = csvValue(row[1], kind)
+		}
+		return records, nil
+	}
+
+	records := make(map[string]json.RawMessage, len(rows)-1)
+	for _, row := range rows[1:] {
+		fields := map[string]json.RawMessage{}
+		for i, cell := range row[1:] {
+			if cell != "" {
+				fields[columns[i+1]] = csvValue(cell, kinds[columns[i+1]])
+			}
+		}
+		raw, err := json.Marshal(fields)
+		if err != nil {
+			return nil, data.JsonMarshalError{OriginalErr: err}
+		}
+		records[row[0]] = raw
+	}
+	return records, nil
+}
+
+// Import stores the records in 'fileName', written by Export, in 'bucket'.
+// Records with the same key are replaced, with 'replace' records missing from
+// the file are deleted too. Every record is checked before anything is
+// stored and the import is recorded in the audit trail
+func Import(cfg *config.Config, bucket, fileName string, replace bool) error {
+	err := checkBucket(bucket)
+	if err != nil {
+		return err
+	}
+	raw, err := os.ReadFile(fileName)
+	if err != nil {
+		return data.ReadWriteFileError{OriginalErr: err}
+	}
+	var records map[string]json.RawMessage
+	if isCSV(fileName) {
+		records, err = readRecordsCSV(bytes.NewReader(raw), bucket)
+	} else {
+		err = json.Unmarshal(raw, &records)
+		if err != nil {
+			err = data.JsonMarshalError{OriginalErr: err}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s | %w", fileName, err)
+	}
+	// decoding into the bucket's record type checks every record and stores
+	// them with the field names the bot writes
+	for key, raw := range records {
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		record := bucketRecords[bucket]()
+		err = decoder.Decode(record)
+		if err != nil {
+			return fmt.Errorf("record %s in %s | %w", key, fileName, data.JsonMarshalError{OriginalErr: err})
+		}
+		records[key], err = json.Marshal(record)
+		if err != nil {
+			return data.JsonMarshalError{OriginalErr: err}
+		}
+	}
+
+	currentConfig.Store(cfg)
+	store, err = openStorage()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	existing, err := loadBucket[json.RawMessage](bucket)
+	if err != nil {
+		return fmt.Errorf("reading bucket %s | %w", bucket, err)
+	}
+	if bucket == storage.SettingsBucket {
+		settings := records
+		if !replace {
+			settings = make(map[string]json.RawMessage, len(existing)+len(records))
+			for key, raw := range existing {
+				settings[key] = raw
+			}
+			for key, raw := range records {
+				settings[key] = raw
+			}
+		}
+		_, err = applySettings(cfg, settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	var deleted int
+	err = store.Update(func(tx storage.Tx) error {
+		for key, raw := range records {
+			err := tx.Put(bucket, key, raw)
+			if err != nil {
+				return err
+			}
+		}
+		if replace {
+			for key := range existing {
+				if _, ok := records[key]; ok {
+					continue
+				}
+				err := tx.Delete(bucket, key)
+				if err != nil {
+					return err
+				}
+				deleted++
+			}
+		}
+		entry := cliAudit("import")
+		entry.Setting, entry.New = bucket, auditValue(fmt.Sprintf("%d records from %s, %d deleted", len(records), filepath.Base(fileName), deleted))
+		return recordAudit(tx, entry)
+	})
+	if err != nil {
+		return fmt.Errorf("importing %s into bucket %s | %w", fileName, bucket, err)
+	}
+	fmt.Printf("imported %d records into %s, %d deleted\n", len(records), bucket, deleted)
+	return nil
+}
+
+// ListProtocols prints every stored protocol's name and twitter url sorted by
+// name
+func ListProtocols(cfg *config.Config) error {
+	currentConfig.Store(cfg)
+	var err error
+	store, err = openStorage()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	stored, err := loadBucket[data.Protocol](storage.ProtocolsBucket)
+	if err != nil {
+		return fmt.Errorf("reading bucket %s | %w", storage.ProtocolsBucket, err)
+	}
+	names := make([]string, 0, len(stored))
+	for name := range stored {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s\t%s\n", name, orNA(stored[name].TwitterURL))
+	}
+	return nil
+}
+
+// SetTwitter sets the twitter url of the protocol 'name', found the same way
+// as by the bot's commands. An empty 'twitterURL' clears it. The change is
+// recorded in the audit trail and can be undone with /undo-twitter
+func SetTwitter(cfg *config.Config, name, twitterURL string) error {
+	err := openStores(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	protocol, ok := findProtocol(name)
+	if !ok {
+		return fmt.Errorf("no protocol named %q, list-protocols lists them", name)
+	}
+	changeTwitterURL(cliAudit("set-twitter"), protocol.Name, strings.TrimSpace(twitterURL))
+	err = saveBucket(storage.ProtocolsBucket, map[string]data.Protocol{protocol.Name: protocols.M[protocol.Name]}, false)
+	if err != nil {
+		return fmt.Errorf("saving bucket %s | %w", storage.ProtocolsBucket, err)
+	}
+	fmt.Printf("set twitter of %s to %s\n", protocol.Name, orNA(protocols.M[protocol.Name].TwitterURL))
+	return nil
+}
+
+// Compact rewrites every .jsonl store file with one line per record, dropping
+// the lines replaced by later ones. Leftover backup files are merged in and
+// malformed lines quarantined as when the bot loads them
+func Compact(cfg *config.Config) error {
+	currentConfig.Store(cfg)
+	if conf().Storage == "bolt" {
+		return errors.New("compact rewrites the .jsonl files, storage is bolt")
+	}
+	for _, bucket := range bucketNames() {
+		fileName := filepath.Join(conf().DataDir, storage.BucketFiles[bucket])
+		lines, err := countRecordLines(fileName)
+		if err != nil {
+			return err
+		}
+		records, err := recoverJsonlRecords(fileName)
+		if err != nil {
+			return fmt.Errorf("reading %s | %w", fileName, err)
+		}
+		err = writeJsonlFile(fileName, records)
+		if err != nil {
+			return fmt.Errorf("rewriting %s | %w", fileName, err)
+		}
+		fmt.Printf("%s: %d lines, %d records\n", fileName, lines, len(records))
+	}
+	return nil
+}
+
+// countRecordLines returns the number of record lines in the .jsonl file
+// 'fileName', not counting the schema header. A missing file has none
+func countRecordLines(fileName string) (int, error) {
+	raw, err := os.ReadFile(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, data.ReadWriteFileError{OriginalErr: err}
+	}
+	lines := 0
+	for _, line := range bytes.Split(raw, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			lines++
+		}
+	}
+	if header, err := schemaHeader(fileName); err == nil && bytes.HasPrefix(raw, []byte(header)) {
+		lines--
+	}
+	return lines, nil
+}
+
+// Backfill runs the daily job for the rounds dated from 'start' to 'end'
+// (2006-01-02 format) like /run-recap, and prints the report. The bot doesn't
+// have to be running, discord is only used to post
+func Backfill(cfg *config.Config, start, end string, dryRun bool, channelID string) error {
+	err := checkRecapRange(start, end)
+	if err != nil {
+		return fmt.Errorf("dates must be YYYY-MM-DD with from no later than to | %w", err)
+	}
+	err = openStores(cfg, cfg.ShadowMode != "")
+	if err != nil {
+		return err
+	}
+	_, err = newSession()
+	if err != nil {
+		store.Close()
+		return err
+	}
+	entry := cliAudit("backfill")
+	if dryRun {
+		entry.Action = "backfill dry-run"
+	}
+	entry.Setting, entry.New = "range", auditValue(start+" to "+end)
+	audit(entry)
+
+	report, err := runRecap(start, end, recapOptions{DryRun: dryRun, ChannelID: channelID})
+	if dryRun {
+		fmt.Printf("dry run from %s to %s, nothing was posted. %d new rounds, %d already announced\n", start, end, report.New, report.Announced)
+	} else {
+		fmt.Printf("ran the recap from %s to %s. %d new rounds, %d already announced\n", start, end, report.New, report.Announced)
+	}
+	for _, line := range report.Lines {
+		fmt.Println(line)
+	}
+	// the rounds are saved by runRecap, protocols and messages waiting for a
+	// twitter pick are saved here
+	gracefulShutdown()
+	return err
+}
+
+// RegisterCommands replaces the bot's slash commands in config.GuildID with
+// the current ones without starting the bot
+func RegisterCommands(cfg *config.Config) error {
+	currentConfig.Store(cfg)
+	session, err := newSession()
+	if err != nil {
+		return err
+	}
+	err = registerCommands(session)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("registered %d commands in guild %s\n", len(commands), conf().GuildID)
+	return nil
+}
+
+// UnregisterCommands removes every slash command of the bot from
+// config.GuildID
+func UnregisterCommands(cfg *config.Config) error {
+	currentConfig.Store(cfg)
+	session, err := newSession()
+	if err != nil {
+		return err
+	}
+	_, err = session.ApplicationCommandBulkOverwrite(BotId, conf().GuildID, []*discordgo.ApplicationCommand{})
+	if err != nil {
+		return fmt.Errorf("removing commands from guild %s | %w", conf().GuildID, err)
+	}
+	fmt.Printf("removed the bot's commands from guild %s\n", conf().GuildID)
+	return nil
+}
@@ -0,0 +1,113 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/readysetliqd/airdrop-discord-bot-go/config"
+)
+
+var (
+	// currentConfig is the config in use, swapped whole on reload
+	currentConfig atomic.Pointer[config.Config]
+	// baseConfig is the config as loaded, before the settings changed with
+	// /config are applied over it
+	baseConfig atomic.Pointer[config.Config]
+	// configMu serializes reloads and /config changes
+	configMu sync.Mutex
+	// configLoader reads the config again from the same file, environment and
+	// flags it was first loaded from
+	configLoader func() (*config.Config, error)
+)
+
+// conf returns the config in use. Code that reads several settings together
+// should take it once so a reload can't change them halfway through
+func conf() *config.Config {
+	return currentConfig.Load()
+}
+
+// swapConfig puts 'next' in use as the config with 'base' as the config it
+// was built from. The daily job is rescheduled if its schedule changed
+func swapConfig(base, next *config.Config) {
+	previous := currentConfig.Swap(next)
+	baseConfig.Store(base)
+	if previous != nil && previous.Schedule != next.Schedule {
+		rescheduleDailyJob()
+	}
+}
+
+// reloadConfig loads the config again with configLoader and swaps it in with
+// the settings changed with /config applied over it. An invalid config or
+// templates that don't render leave the current config in use and return the
+// error. Settings that only apply on startup keep their current value, they're
+// returned so operators know a restart is needed
+func reloadConfig() ([]string, error) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	if configLoader == nil {
+		return nil, errors.New("config can't be reloaded, it wasn't loaded from a file")
+	}
+	next, err := configLoader()
+	if err != nil {
+		return nil, err
+	}
+
+	current := conf()
+	var restart []string
+	keep := func(name string, currentValue, nextValue *string) {
+		if *currentValue != *nextValue {
+			restart = append(restart, name)
+			*nextValue = *currentValue
+		}
+	}
+	keep("token", &current.Token, &next.Token)
+	keep("guildID", &current.GuildID, &next.GuildID)
+	keep("storage", &current.Storage, &next.Storage)
+	keep("databaseFile", &current.DatabaseFile, &next.DatabaseFile)
+	keep("dataDir", &current.DataDir, &next.DataDir)
+	keep("shadowMode", &current.ShadowMode, &next.ShadowMode)
+	keep("shadowChannelID", &current.ShadowChannelID, &next.ShadowChannelID)
+	keep("shadowLogFile", &current.ShadowLogFile, &next.ShadowLogFile)
+
+	effective, err := withSettings(next)
+	if err != nil {
+		return nil, err
+	}
+	err = loadTemplates(effective.TemplatesDir)
+	if err != nil {
+		return nil, fmt.Errorf("templates in %s | %w", effective.TemplatesDir, err)
+	}
+	swapConfig(next, effective)
+	return restart, nil
+}
+
+// reloadConfigAndReport reloads the config and returns a message describing
+// the result for operators
+func reloadConfigAndReport() (string, error) {
+	restart, err := reloadConfig()
+	if err != nil {
+		return "", err
+	}
+	if len(restart) > 0 {
+		return fmt.Sprintf("Config reloaded. Restart the bot to apply %s.", strings.Join(restart, ", ")), nil
+	}
+	return "Config reloaded.", nil
+}
+
+// reloadConfigCommandHandler responds to the /reload-config command by
+// reloading the config
+func reloadConfigCommandHandler(s discordSession, i *discordgo.InteractionCreate) {
+	content, err := reloadConfigAndReport()
+	if err != nil {
+		log.Println("reloading config |", err)
+		content = fmt.Sprintf("Config not reloaded, still using the current one.\n```\n%s\n```", truncate(err.Error(), 1800))
+	} else {
+		audit(memberAudit(i.Member, "reload-config"))
+	}
+	interactionReply(s, i, content)
+}
@@ -0,0 +1,198 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+)
+
+const (
+	// fundRecentRoundsShown is the number of most recent rounds listed on a
+	// /fund embed
+	fundRecentRoundsShown = 5
+	// fundFavouriteCategoriesShown is the number of categories listed as a
+	// fund's favourites on a /fund embed
+	fundFavouriteCategoriesShown = 3
+)
+
+// recordFundRounds adds or updates the profile of every fund that took part in
+// the funding round 'entry' and appends the round to each fund's history. A
+// round already recorded for a fund (same protocol, date and stage) is skipped.
+// Funds are only marked modified when a round is added or the profile changes
+func recordFundRounds(entry data.RespData) {
+	for _, respFund := range entry.Funds {
+		key := respFund.Key
+		if key == "" {
+			key = respFund.Name
+		}
+		stored, ok := funds.M[key]
+		fund := stored
+		fund.Name = respFund.Name
+		fund.Key = key
+		fund.Image = respFund.Image
+		fund.Tier = respFund.Tier
+		fund.Type = respFund.Type
+		fund.Category = respFund.Category.Name
+		fund.TotalInvestments = respFund.TotalInvestments
+		changed := !ok || fund.Name != stored.Name || fund.Image != stored.Image || fund.Tier != stored.Tier ||
+			fund.Type != stored.Type || fund.Category != stored.Category || fund.TotalInvestments != stored.TotalInvestments
+
+		seen := false
+		for _, round := range fund.Rounds {
+			if round.Protocol == entry.Name && round.Date.Equal(entry.Date) && round.Stage == entry.Stage {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			fund.Rounds = append(fund.Rounds, data.FundRound{
+				Protocol:  entry.Name,
+				Date:      entry.Date,
+				Stage:     entry.Stage,
+				Category:  entry.Category.Name,
+				Raise:     entry.Raise,
+				FundCount: len(entry.Funds),
+			})
+			changed = true
+		}
+		if changed {
+			funds.M[key] = fund
+			funds.Modified = true
+		}
+	}
+}
+
+// findFund looks up a fund by its key or by name, case insensitive. If no exact
+// match is found it falls back to a partial name match but only if exactly one
+// fund matches
+func findFund(name string) (data.Fund, bool) {
+	if fund, ok := funds.M[name]; ok {
+		return fund, true
+	}
+	var partial []data.Fund
+	for _, fund := range funds.M {
+		if strings.EqualFold(fund.Name, name) || strings.EqualFold(fund.Key, name) {
+			return fund, true
+		}
+		if stringContainsCaseIns(fund.Name, name) {
+			partial = append(partial, fund)
+		}
+	}
+	if len(partial) == 1 {
+		return partial[0], true
+	}
+	return data.Fund{}, false
+}
+
+// averageTicketSize estimates a fund's average ticket size by splitting the
+// raise of every round it took part in evenly between the round's funds.
+// Rounds with an undisclosed raise are ignored. Returns 0 if there are none
+func averageTicketSize(fund data.Fund) int {
+	var total, count int
+	for _, round := range fund.Rounds {
+		if round.Raise == 0 || round.FundCount == 0 {
+			continue
+		}
+		total += round.Raise / round.FundCount
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return total / count
+}
+
+// favouriteCategories returns up to 'n' of the categories a fund has invested in
+// most often, formatted with the number of rounds in each
+func favouriteCategories(fund data.Fund, n int) []string {
+	counts := map[string]int{}
+	for _, round := range fund.Rounds {
+		if round.Category != "" {
+			counts[round.Category]++
+		}
+	}
+	categories := make([]string, 0, len(counts))
+	for category := range counts {
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if counts[categories[i]] != counts[categories[j]] {
+			return counts[categories[i]] > counts[categories[j]]
+		}
+		return categories[i] < categories[j]
+	})
+	if len(categories) > n {
+		categories = categories[:n]
+	}
+	for i, category := range categories {
+		categories[i] = fmt.Sprintf("%s (%d)", category, counts[category])
+	}
+	return categories
+}
+
+// fundEmbed builds the profile embed sent in response to the /fund command
+func fundEmbed(fund data.Fund) *discordgo.MessageEmbed {
+	tier := "Untiered"
+	if fund.Tier > 0 {
+		tier = fmt.Sprintf("Tier %d", fund.Tier)
+	}
+
+	var recent strings.Builder
+	for i := len(fund.Rounds) - 1; i >= 0 && i >= len(fund.Rounds)-fundRecentRoundsShown; i-- {
+		round := fund.Rounds[i]
+		fmt.Fprintf(&recent, "%s | %s | %s | %s\n", round.Date.Format("2006-01-02"), round.Protocol, round.Stage, raiseToString(round.Raise))
+	}
+
+	category := fund.Category
+	if category == "" {
+		category = "N/A"
+	}
+
+	favourites := strings.Join(favouriteCategories(fund, fundFavouriteCategoriesShown), ", ")
+	if favourites == "" {
+		favourites = "N/A"
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       fund.Name,
+		Description: fund.Type,
+		Color:       embedColor,
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: fund.Image,
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Tier", Value: tier, Inline: true},
+			{Name: "Category", Value: category, Inline: true},
+			{Name: "Total Investments", Value: fmt.Sprint(fund.TotalInvestments), Inline: true},
+			{Name: "Avg Ticket Size (est.)", Value: raiseToString(averageTicketSize(fund)), Inline: true},
+			{Name: "Rounds Seen", Value: fmt.Sprint(len(fund.Rounds)), Inline: true},
+			{Name: "Favourite Categories", Value: favourites},
+			{Name: "Recent Rounds", Value: recent.String()},
+		},
+	}
+}
+
+// fundCommandHandler responds to the /fund command with the profile embed of
+// the fund passed as the 'name' option
+func fundCommandHandler(s discordSession, i *discordgo.InteractionCreate) {
+	name := i.ApplicationCommandData().Options[0].StringValue()
+	fund, ok := findFund(name)
+	if !ok {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "No fund by that name has been seen in any funding round yet, check spelling.",
+			},
+		})
+		return
+	}
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{fundEmbed(fund)},
+		},
+	})
+}
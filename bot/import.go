@@ -0,0 +1,57 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/readysetliqd/airdrop-discord-bot-go/config"
+	"github.com/readysetliqd/airdrop-discord-bot-go/storage"
+)
+
+// ImportJsonl copies every record of the .jsonl store files in config.DataDir
+// into the bolt database at config.DatabaseFile, one transaction per bucket. Records already
+// in the database under the same key are replaced so it's safe to run again.
+// The .jsonl files are left as they are
+func ImportJsonl(cfg *config.Config) error {
+	currentConfig.Store(cfg)
+	db, err := openBolt()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	jsonl := newJsonlStorage(conf().DataDir)
+
+	buckets := make([]string, 0, len(storage.BucketFiles))
+	for bucket := range storage.BucketFiles {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	for _, bucket := range buckets {
+		records := map[string]json.RawMessage{}
+		err = jsonl.View(func(tx storage.Tx) error {
+			return tx.ForEach(bucket, func(key string, raw json.RawMessage) error {
+				records[key] = raw
+				return nil
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("reading %s | %w", storage.BucketFiles[bucket], err)
+		}
+		err = db.Update(func(tx storage.Tx) error {
+			for key, raw := range records {
+				err := tx.Put(bucket, key, raw)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("importing %s into %s | %w", storage.BucketFiles[bucket], databasePath(), err)
+		}
+		log.Printf("imported %d records from %s\n", len(records), storage.BucketFiles[bucket])
+	}
+	return nil
+}
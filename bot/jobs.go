@@ -0,0 +1,367 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/readysetliqd/airdrop-discord-bot-go/config"
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+	"github.com/readysetliqd/airdrop-discord-bot-go/storage"
+)
+
+// alertColor is the color of operator alert embeds
+const alertColor = 15158332
+
+// dailyJobRescheduled wakes runDailyJob to work out its next run again
+var dailyJobRescheduled = make(chan struct{}, 1)
+
+// runDailyJob runs the recap of the last 24 hours at the time of day set by
+// config.Schedule, or every 24 hours from the last run when it isn't set.
+// Failures are reported to the operators
+func runDailyJob() {
+	last := time.Now()
+	for {
+		timer := time.NewTimer(time.Until(nextDailyRun(conf().Schedule, last, time.Now())))
+		select {
+		case <-dailyJobRescheduled:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+		last = time.Now()
+		start := last.Add(time.Hour * -24).Format("2006-01-02")
+		end := last.Format("2006-01-02")
+		_, err := runRecap(start, end, recapOptions{})
+		if err != nil {
+			sendOperatorAlert("Daily funding rounds job failed", err)
+		}
+	}
+}
+
+// rescheduleDailyJob makes runDailyJob pick up a changed schedule
+func rescheduleDailyJob() {
+	select {
+	case dailyJobRescheduled <- struct{}{}:
+	default:
+	}
+}
+
+// nextDailyRun returns when the daily job runs next after 'now' for the
+// HH:MM 'schedule', or 24 hours after 'last' if 'schedule' is empty
+func nextDailyRun(schedule string, last, now time.Time) time.Time {
+	if schedule == "" {
+		return last.Add(24 * time.Hour)
+	}
+	at, err := time.Parse("15:04", schedule)
+	if err != nil {
+		// the config is validated so this shouldn't happen
+		log.Printf("parsing schedule %q | %v\n", schedule, err)
+		return last.Add(24 * time.Hour)
+	}
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, time.UTC)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// recapMu keeps the daily job and /run-recap from posting at the same time
+var recapMu sync.Mutex
+
+// recapOptions change what runRecap does
+type recapOptions struct {
+	// DryRun posts and stores nothing, the report shows what would be posted
+	DryRun bool
+	// ChannelID is where to post instead of config.DefaultChannelID, rounds
+	// are posted there without threads and aren't stored as announced so the
+	// daily job still posts them to the announcement channel
+	ChannelID string
+}
+
+// recapReport is what runRecap posted or, in a dry run, would have posted.
+// Lines describe each round
+type recapReport struct {
+	Recap     *discordgo.MessageEmbed
+	Lines     []string
+	New       int
+	Announced int
+}
+
+// runRecap runs the daily job for funding rounds dated between 'start' and
+// 'end' (2006-01-02 format). It queries cryptorank, sends the recap and an
+// embed for each round, then saves the posted rounds to storage unless
+// opts.ChannelID posts them elsewhere. Rounds already stored are left out,
+// nothing is posted when every round was announced before. Temporary failures
+// are retried, errors from single rounds don't stop the rest of the rounds
+// from being posted
+func runRecap(start, end string, opts recapOptions) (recapReport, error) {
+	recapMu.Lock()
+	defer recapMu.Unlock()
+	var report recapReport
+	var respDataStructs *[]data.RespData
+	err := withRetry(func() error {
+		var err error
+		respDataStructs, err = queryCryptoRank(start, end)
+		return err
+	})
+	if err != nil {
+		return report, fmt.Errorf("querying cryptorank for rounds from %s to %s | %w", start, end, err)
+	}
+	fresh, err := unannouncedRounds(*respDataStructs)
+	if err != nil {
+		return report, err
+	}
+	report.New, report.Announced = len(fresh), len(*respDataStructs)-len(fresh)
+	for _, entry := range *respDataStructs {
+		report.Lines = append(report.Lines, describeRecapRound(entry, !containsRound(fresh, entry)))
+	}
+	if len(fresh) == 0 && report.Announced > 0 {
+		return report, nil
+	}
+
+	report.Recap, err = recapEmbed(&fresh, start, end)
+	if err != nil {
+		return report, err
+	}
+	if opts.DryRun {
+		return report, nil
+	}
+	var errs []error
+	err = sendFundingRoundsRecapEmbed(&fresh, start, end, opts.ChannelID)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	rounds, err := sendIndividualFundingRoundsEmbeds(&fresh, opts.ChannelID)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	overridden := opts.ChannelID != "" && opts.ChannelID != conf().DefaultChannelID
+	if len(rounds) > 0 && !overridden {
+		err = saveBucket(storage.RoundsBucket, rounds, false)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("saving bucket %s | %w", storage.RoundsBucket, err))
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// unannouncedRounds returns the rounds in 'entries' that aren't stored yet. A
+// round is stored when one of the same protocol, date and stage is
+func unannouncedRounds(entries []data.RespData) ([]data.RespData, error) {
+	var fresh []data.RespData
+	err := store.View(func(tx storage.Tx) error {
+		for _, entry := range entries {
+			keys, err := tx.Lookup(storage.RoundsBucket, storage.ProtocolIndex, entry.Name)
+			if err != nil {
+				return err
+			}
+			announced := false
+			for _, key := range keys {
+				var round data.Round
+				ok, err := tx.Get(storage.RoundsBucket, key, &round)
+				if err != nil {
+					return err
+				}
+				if ok && round.Date == entry.Date.Format("2006-01-02") && strings.EqualFold(round.Stage, entry.Stage) {
+					announced = true
+					break
+				}
+			}
+			if !announced {
+				fresh = append(fresh, entry)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading bucket %s | %w", storage.RoundsBucket, err)
+	}
+	return fresh, nil
+}
+
+// containsRound returns true if 'entries' has a round of the same protocol,
+// date and stage as 'entry'
+func containsRound(entries []data.RespData, entry data.RespData) bool {
+	for _, e := range entries {
+		if e.Name == entry.Name && e.Date.Equal(entry.Date) && e.Stage == entry.Stage {
+			return true
+		}
+	}
+	return false
+}
+
+// describeRecapRound returns a line describing what the recap does with the
+// round 'entry' for the /run-recap report
+func describeRecapRound(entry data.RespData, announced bool) string {
+	line := fmt.Sprintf("**%s** %s %s", entry.Name, entry.Date.Format("2006-01-02"), entry.Stage)
+	if announced {
+		return line + ", already announced"
+	}
+	score := scoreRound(entry)
+	if score < conf().Scoring.MinIndividualScore {
+		return line + fmt.Sprintf(", score %.1f, recap only", score)
+	}
+	line += fmt.Sprintf(", score %.1f, own embed", score)
+	if _, err := renderEmbed(roundTemplate, roundView{RespData: entry, Score: score}); err != nil {
+		line += " that fails to render: " + err.Error()
+	}
+	ping := matchPingRules(entry)
+	if len(ping.RoleIDs) > 0 {
+		mentions := make([]string, len(ping.RoleIDs))
+		for i, roleID := range ping.RoleIDs {
+			mentions[i] = config.RoleMention(roleID)
+		}
+		line += fmt.Sprintf(", pings %s for %s", strings.Join(mentions, " "), strings.Join(ping.Reasons, ", "))
+	}
+	return line
+}
+
+// withRetry calls 'fn' until it succeeds, returns a non-temporary error or
+// data.MaxRequestAttempts attempts have been made. The delay between attempts
+// starts at data.RequestRetryBaseDelay and doubles each retry up to
+// data.RequestRetryMaxDelay. Returns data.RetriesExhaustedError if every
+// attempt failed with a temporary error
+func withRetry(fn func() error) error {
+	delay := data.RequestRetryBaseDelay
+	var err error
+	for attempt := 1; attempt <= data.MaxRequestAttempts; attempt++ {
+		err = fn()
+		if err == nil || !data.IsTemporary(err) {
+			return err
+		}
+		if attempt == data.MaxRequestAttempts {
+			break
+		}
+		log.Printf("attempt %d of %d failed, retrying in %v | %v\n", attempt, data.MaxRequestAttempts, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+		if delay > data.RequestRetryMaxDelay {
+			delay = data.RequestRetryMaxDelay
+		}
+	}
+	return data.RetriesExhaustedError{Attempts: data.MaxRequestAttempts, OriginalErr: err}
+}
+
+// sendOperatorAlert posts 'err' to config.AlertChannelID and tags the bot
+// operator role so someone can look into it. It only logs if no alert channel
+// is configured or the alert itself can't be sent
+func sendOperatorAlert(title string, err error) {
+	log.Printf("%s | %v\n", title, err)
+	if conf().AlertChannelID == "" {
+		return
+	}
+	description := err.Error()
+	if shortened := truncate(description, 4000); shortened != description {
+		description = shortened + "..."
+	}
+	msg := &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{{
+			Title:       title,
+			Description: description,
+			Color:       alertColor,
+			Timestamp:   time.Now().Format(time.RFC3339),
+		}},
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}
+	if conf().BotOperatorRoleID != "" {
+		msg.Content = config.RoleMention(conf().BotOperatorRoleID)
+		msg.AllowedMentions.Roles = []string{conf().BotOperatorRoleID}
+	}
+	_, sendErr := s.ChannelMessageSendComplex(conf().AlertChannelID, msg)
+	if sendErr != nil {
+		log.Printf("sending alert to channel %s | %v\n", conf().AlertChannelID, sendErr)
+	}
+}
+
+// checkRecapRange returns an error unless 'start' and 'end' are dates in
+// 2006-01-02 format with 'start' no later than 'end'
+func checkRecapRange(start, end string) error {
+	from, err := time.Parse("2006-01-02", start)
+	if err != nil {
+		return err
+	}
+	to, err := time.Parse("2006-01-02", end)
+	if err != nil {
+		return err
+	}
+	if to.Before(from) {
+		return errors.New("to is before from")
+	}
+	return nil
+}
+
+// runRecapCommandHandler responds to the /run-recap command by running the
+// daily job for the rounds dated from 'from' to 'to'. With 'dry_run' nothing is
+// posted and the operator is shown privately what would be, 'channel' posts
+// somewhere other than the default channel
+func runRecapCommandHandler(s discordSession, i *discordgo.InteractionCreate) {
+	var start, end string
+	var opts recapOptions
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "from":
+			start = strings.TrimSpace(option.StringValue())
+		case "to":
+			end = strings.TrimSpace(option.StringValue())
+		case "dry_run":
+			opts.DryRun = option.BoolValue()
+		case "channel":
+			opts.ChannelID = optionID(option)
+		}
+	}
+	err := checkRecapRange(start, end)
+	if err != nil {
+		ephemeralReply(s, i, fmt.Sprintf("Dates must be YYYY-MM-DD with from no later than to, %v.", err))
+		return
+	}
+
+	// the recap takes longer than discord waits for a response
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	})
+	if err != nil {
+		log.Println("responding to interaction |", err)
+		return
+	}
+	entry := memberAudit(i.Member, "run-recap")
+	if opts.DryRun {
+		entry.Action = "run-recap dry-run"
+	}
+	entry.Setting, entry.New = "range", auditValue(start+" to "+end)
+	audit(entry)
+
+	report, err := runRecap(start, end, opts)
+	var content strings.Builder
+	switch {
+	case opts.DryRun:
+		fmt.Fprintf(&content, "Dry run from %s to %s, nothing was posted. %d new rounds, %d already announced.\n", start, end, report.New, report.Announced)
+	default:
+		fmt.Fprintf(&content, "Ran the recap from %s to %s. %d new rounds, %d already announced.\n", start, end, report.New, report.Announced)
+	}
+	for _, line := range report.Lines {
+		content.WriteString(line + "\n")
+	}
+	if err != nil {
+		log.Println("run-recap |", err)
+		fmt.Fprintf(&content, "```\n%s\n```", truncate(err.Error(), 500))
+	}
+	text := truncate(content.String(), 2000)
+	edit := &discordgo.WebhookEdit{
+		Content:         &text,
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}
+	if opts.DryRun && report.Recap != nil {
+		edit.Embeds = &[]*discordgo.MessageEmbed{report.Recap}
+	}
+	_, err = s.InteractionResponseEdit(i.Interaction, edit)
+	if err != nil {
+		log.Println("editing interaction response |", err)
+	}
+}
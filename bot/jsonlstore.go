@@ -0,0 +1,242 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+	"github.com/readysetliqd/airdrop-discord-bot-go/storage"
+)
+
+// jsonlStorage is the storage.Storage backed by the original .jsonl files.
+// Buckets are read into memory on first use, committing an Update appends the
+// new keys to the file or overwrites it if existing keys were changed or
+// deleted. Transactions are serialized, an Update touching several buckets
+// writes each file separately so it isn't atomic across buckets
+type jsonlStorage struct {
+	mu      sync.Mutex
+	dir     string
+	buckets map[string]map[string]json.RawMessage
+}
+
+// newJsonlStorage returns a jsonlStorage keeping its files in the directory
+// 'dir'
+func newJsonlStorage(dir string) *jsonlStorage {
+	return &jsonlStorage{dir: dir, buckets: map[string]map[string]json.RawMessage{}}
+}
+
+// fileName returns the path of the file 'bucket' is stored in
+func (st *jsonlStorage) fileName(bucket string) (string, error) {
+	fileName, ok := storage.BucketFiles[bucket]
+	if !ok {
+		return "", fmt.Errorf("unknown bucket %s", bucket)
+	}
+	return filepath.Join(st.dir, fileName), nil
+}
+
+// load returns the records of 'bucket', reading its file the first time
+func (st *jsonlStorage) load(bucket string) (map[string]json.RawMessage, error) {
+	if records, ok := st.buckets[bucket]; ok {
+		return records, nil
+	}
+	fileName, err := st.fileName(bucket)
+	if err != nil {
+		return nil, err
+	}
+	records, err := loadJsonlFile[json.RawMessage](fileName)
+	if err != nil {
+		return nil, fmt.Errorf("loading file %s | %w", fileName, err)
+	}
+	st.buckets[bucket] = records
+	return records, nil
+}
+
+func (st *jsonlStorage) View(fn func(tx storage.Tx) error) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return fn(&jsonlTx{st: st})
+}
+
+func (st *jsonlStorage) Update(fn func(tx storage.Tx) error) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	tx := &jsonlTx{
+		st:       st,
+		writable: true,
+		puts:     map[string]map[string]json.RawMessage{},
+		deletes:  map[string]map[string]bool{},
+	}
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+	return tx.commit()
+}
+
+func (st *jsonlStorage) Close() error {
+	return nil
+}
+
+// jsonlTx keeps the writes of a transaction apart from the loaded records
+// until it's committed
+type jsonlTx struct {
+	st       *jsonlStorage
+	writable bool
+	puts     map[string]map[string]json.RawMessage
+	deletes  map[string]map[string]bool
+}
+
+func (t *jsonlTx) raw(bucket, key string) (json.RawMessage, bool, error) {
+	if raw, ok := t.puts[bucket][key]; ok {
+		return raw, true, nil
+	}
+	if t.deletes[bucket][key] {
+		return nil, false, nil
+	}
+	records, err := t.st.load(bucket)
+	if err != nil {
+		return nil, false, err
+	}
+	raw, ok := records[key]
+	return raw, ok, nil
+}
+
+func (t *jsonlTx) Get(bucket, key string, v any) (bool, error) {
+	raw, ok, err := t.raw(bucket, key)
+	if err != nil || !ok {
+		return false, err
+	}
+	err = json.Unmarshal(raw, v)
+	if err != nil {
+		return false, data.JsonMarshalError{OriginalErr: err}
+	}
+	return true, nil
+}
+
+func (t *jsonlTx) Put(bucket, key string, v any) error {
+	if !t.writable {
+		return errors.New("put in a read only transaction")
+	}
+	if _, err := t.st.load(bucket); err != nil {
+		return err
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return data.JsonMarshalError{OriginalErr: err}
+	}
+	if t.puts[bucket] == nil {
+		t.puts[bucket] = map[string]json.RawMessage{}
+	}
+	t.puts[bucket][key] = raw
+	delete(t.deletes[bucket], key)
+	return nil
+}
+
+func (t *jsonlTx) Delete(bucket, key string) error {
+	if !t.writable {
+		return errors.New("delete in a read only transaction")
+	}
+	if _, err := t.st.load(bucket); err != nil {
+		return err
+	}
+	if t.deletes[bucket] == nil {
+		t.deletes[bucket] = map[string]bool{}
+	}
+	t.deletes[bucket][key] = true
+	delete(t.puts[bucket], key)
+	return nil
+}
+
+func (t *jsonlTx) ForEach(bucket string, fn func(key string, raw json.RawMessage) error) error {
+	records, err := t.st.load(bucket)
+	if err != nil {
+		return err
+	}
+	for key := range records {
+		raw, ok, _ := t.raw(bucket, key)
+		if !ok {
+			continue
+		}
+		err = fn(key, raw)
+		if err != nil {
+			return err
+		}
+	}
+	for key, raw := range t.puts[bucket] {
+		if _, ok := records[key]; ok {
+			continue
+		}
+		err = fn(key, raw)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lookup scans the whole bucket, the jsonl backend keeps no indexes
+func (t *jsonlTx) Lookup(bucket, index, value string) ([]string, error) {
+	if _, ok := storage.Indexes[bucket][index]; !ok {
+		return nil, fmt.Errorf("unknown index %s on bucket %s", index, bucket)
+	}
+	value = storage.NormalizeIndexValue(value)
+	var keys []string
+	err := t.ForEach(bucket, func(key string, raw json.RawMessage) error {
+		if containsString(storage.IndexValues(bucket, raw)[index], value) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// commit writes every bucket changed by the transaction to its file and then
+// to the loaded records. Buckets where only new keys were added are appended,
+// the rest are overwritten
+func (t *jsonlTx) commit() error {
+	changed := map[string]bool{}
+	for bucket := range t.puts {
+		changed[bucket] = true
+	}
+	for bucket := range t.deletes {
+		changed[bucket] = true
+	}
+	for bucket := range changed {
+		records := t.st.buckets[bucket]
+		fileName, err := t.st.fileName(bucket)
+		if err != nil {
+			return err
+		}
+		updated := make(map[string]json.RawMessage, len(records)+len(t.puts[bucket]))
+		for key, raw := range records {
+			updated[key] = raw
+		}
+		appendOnly := true
+		for key := range t.deletes[bucket] {
+			if _, ok := records[key]; ok {
+				appendOnly = false
+				delete(updated, key)
+			}
+		}
+		for key, raw := range t.puts[bucket] {
+			if _, ok := records[key]; ok {
+				appendOnly = false
+			}
+			updated[key] = raw
+		}
+
+		if appendOnly {
+			err = AppendToFile(fileName, t.puts[bucket])
+		} else {
+			err = writeJsonlFile(fileName, updated)
+		}
+		if err != nil {
+			return fmt.Errorf("writing bucket %s to %s | %w", bucket, fileName, err)
+		}
+		t.st.buckets[bucket] = updated
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/readysetliqd/airdrop-discord-bot-go/config"
+)
+
+// commandCapabilities is the capability needed to use each command. Commands
+// left out can't be used by anyone
+var commandCapabilities = map[string]string{
+	"list-protocols": config.CapView,
+	"get-twitter":    config.CapView,
+	"fund":           config.CapView,
+	"protocol":       config.CapView,
+	"search-quota":   config.CapView,
+	"edit-protocol":  config.CapEditProtocol,
+	"undo-twitter":   config.CapResolveTwitter,
+	"run-recap":      config.CapTriggerRun,
+	"reload-config":  config.CapConfigure,
+	"config":         config.CapConfigure,
+	"audit":          config.CapViewAudit,
+}
+
+// reactionEmojis are the reactions the bot acts on, besides the twitter emoji
+var reactionEmojis = []string{"1️⃣", "2️⃣", "3️⃣", "⬅️", "➡️", "❌"}
+
+// can returns true if the user 'userID' in the guild 'guildID' has
+// 'capability' there. 'member' is nil outside a guild, where only grants to
+// the user from config.Permissions count
+func can(capability, guildID, userID string, member *discordgo.Member) bool {
+	grant := conf().Grant(guildID, capability)
+	if userID != "" && containsString(grant.Users, userID) {
+		return true
+	}
+	if member == nil || guildID == "" {
+		return false
+	}
+	if grant.Administrator && member.Permissions&discordgo.PermissionAdministrator != 0 {
+		return true
+	}
+	for _, roleID := range grant.Roles {
+		// members aren't listed with the @everyone role, its ID is the guild's
+		if roleID != "" && (roleID == guildID || containsString(member.Roles, roleID)) {
+			return true
+		}
+	}
+	return false
+}
+
+// interactionUserID returns the ID of the user who sent the interaction 'i'
+func interactionUserID(i *discordgo.InteractionCreate) string {
+	switch {
+	case i.Member != nil && i.Member.User != nil:
+		return i.Member.User.ID
+	case i.User != nil:
+		return i.User.ID
+	}
+	return ""
+}
+
+// commandAllowed returns true if the user who sent the command 'i' has the
+// capability it needs, otherwise it tells them privately and returns false
+func commandAllowed(s discordSession, i *discordgo.InteractionCreate) bool {
+	name := i.ApplicationCommandData().Name
+	capability, ok := commandCapabilities[name]
+	if ok && can(capability, i.GuildID, interactionUserID(i), i.Member) {
+		return true
+	}
+	content := fmt.Sprintf("You don't have the %s permission needed for /%s, ask a bot operator.", capability, name)
+	if !ok {
+		content = fmt.Sprintf("/%s isn't available.", name)
+	}
+	ephemeralReply(s, i, content)
+	return false
+}
+
+// reactionAllowed returns true if the user who added the reaction 'm' has
+// 'capability'. Reactions by the bot itself are never allowed, the bot adds
+// the reactions operators pick from. Reactions the bot would act on from users
+// without the capability are removed, a reaction can't be answered privately
+func reactionAllowed(s discordSession, m *discordgo.MessageReactionAdd, capability string) bool {
+	if m.UserID == BotId {
+		return false
+	}
+	if can(capability, m.GuildID, m.UserID, m.Member) {
+		return true
+	}
+	if _, ok := unprocessedMessages.M[m.MessageID]; ok && (m.Emoji.Name == conf().TwitterEmojiName || containsString(reactionEmojis, m.Emoji.Name)) {
+		log.Printf("removing %s reaction by %s without the %s permission\n", m.Emoji.Name, m.UserID, capability)
+		err := s.MessageReactionRemove(m.ChannelID, m.MessageID, m.Emoji.APIName(), m.UserID)
+		if err != nil {
+			log.Println("removing reaction |", err)
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/readysetliqd/airdrop-discord-bot-go/config"
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+)
+
+// rolePing is every role to tag for a funding round along with the reasons
+// they matched, both without duplicates and in the order they first matched
+type rolePing struct {
+	RoleIDs []string
+	Reasons []string
+}
+
+// addRole adds 'roleID' and 'reason' to the ping unless already present
+func (p *rolePing) addRole(roleID, reason string) {
+	if !containsString(p.RoleIDs, roleID) {
+		p.RoleIDs = append(p.RoleIDs, roleID)
+	}
+	if !containsString(p.Reasons, reason) {
+		p.Reasons = append(p.Reasons, reason)
+	}
+}
+
+// message builds a single reply tagging every role in the ping followed by a
+// line listing the reasons. Mentions are limited to exactly those roles
+func (p rolePing) message() *discordgo.MessageSend {
+	mentions := make([]string, len(p.RoleIDs))
+	for i, roleID := range p.RoleIDs {
+		mentions[i] = config.RoleMention(roleID)
+	}
+	return &discordgo.MessageSend{
+		Content: strings.Join(mentions, " ") + "\n" + strings.Join(p.Reasons, ", "),
+		AllowedMentions: &discordgo.MessageAllowedMentions{
+			Roles: p.RoleIDs,
+		},
+	}
+}
+
+// matchPingRules checks a funding round against config.PingRules and returns
+// every role that should be tagged for it
+func matchPingRules(entry data.RespData) rolePing {
+	var ping rolePing
+	for _, rule := range conf().PingRules {
+		if rule.RoleID == "" {
+			continue
+		}
+		if rule.Stage != "" && !stringContainsCaseIns(entry.Stage, rule.Stage) {
+			continue
+		}
+		if rule.FundName == "" && rule.FundTier == 0 {
+			// stage only rule
+			if rule.Stage != "" {
+				ping.addRole(rule.RoleID, entry.Stage)
+			}
+			continue
+		}
+		for _, fund := range entry.Funds {
+			if rule.FundTier != 0 && fund.Tier != rule.FundTier {
+				continue
+			}
+			if rule.FundName != "" && !stringContainsCaseIns(fund.Name, rule.FundName) {
+				continue
+			}
+			ping.addRole(rule.RoleID, fundReason(fund))
+		}
+	}
+	return ping
+}
+
+// fundReason formats a fund as a ping reason e.g. "Binance Labs (T1)"
+func fundReason(fund data.RespFund) string {
+	if fund.Tier == 0 {
+		return fund.Name
+	}
+	return fmt.Sprintf("%s (T%d)", fund.Name, fund.Tier)
+}
+
+// containsString returns true if 'elems' contains 's'
+func containsString(elems []string, s string) bool {
+	for _, elem := range elems {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,287 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+	"github.com/readysetliqd/airdrop-discord-bot-go/storage"
+)
+
+// protocolRoundsShown is the most rounds listed on a /protocol embed, discord
+// limits embed field values to 1024 characters
+const protocolRoundsShown = 10
+
+// protocolLinkFields are the editable link fields of a protocol, by the name
+// used for them in the /edit-protocol command
+var protocolLinkFields = map[string]func(p *data.Protocol) *string{
+	"website":  func(p *data.Protocol) *string { return &p.Website },
+	"docs":     func(p *data.Protocol) *string { return &p.Docs },
+	"discord":  func(p *data.Protocol) *string { return &p.Discord },
+	"github":   func(p *data.Protocol) *string { return &p.GitHub },
+	"telegram": func(p *data.Protocol) *string { return &p.Telegram },
+}
+
+// cryptoRankLinkFields maps the link types on a cryptorank project page to the
+// protocol field they fill
+var cryptoRankLinkFields = map[string]string{
+	"web":           "website",
+	"website":       "website",
+	"docs":          "docs",
+	"documentation": "docs",
+	"gitbook":       "docs",
+	"whitepaper":    "docs",
+	"discord":       "discord",
+	"github":        "github",
+	"telegram":      "telegram",
+}
+
+// fillProtocolLinks fills in the links and chains of the protocol being
+// resolved by 'q' from its cryptorank project. Only empty fields are filled so
+// operator edits are kept. Protocols already filled once, found by their
+// cryptorank key, are skipped. Errors are logged, the links are nice to have
+func fillProtocolLinks(ctx context.Context, q *HandleQuery) {
+	protocol, ok := protocols.M[q.Name]
+	if !ok || protocol.Key != "" {
+		return
+	}
+	project, err := q.Project(ctx)
+	if err != nil {
+		log.Printf("getting cryptorank project for %s | %v\n", q.Name, err)
+		return
+	}
+	if project == nil {
+		return
+	}
+
+	protocol.Key = q.Key
+	for _, link := range project.Data.Links {
+		field, ok := cryptoRankLinkFields[strings.ToLower(link.Type)]
+		if !ok || link.Value == "" {
+			continue
+		}
+		if value := protocolLinkFields[field](&protocol); *value == "" {
+			*value = link.Value
+		}
+	}
+	if len(protocol.Chains) == 0 {
+		for _, contract := range project.Data.Contracts {
+			if chain := contract.Platform.Name; chain != "" && !containsString(protocol.Chains, chain) {
+				protocol.Chains = append(protocol.Chains, chain)
+			}
+		}
+	}
+	if !protocol.New {
+		protocols.Modified = true
+	}
+	protocols.M[q.Name] = protocol
+}
+
+// findProtocol looks up a protocol by name, case insensitive. If no exact match
+// is found it falls back to a partial name match but only if exactly one
+// protocol matches
+func findProtocol(name string) (data.Protocol, bool) {
+	if protocol, ok := protocols.M[name]; ok {
+		return protocol, true
+	}
+	var partial []data.Protocol
+	for _, protocol := range protocols.M {
+		if strings.EqualFold(protocol.Name, name) || (protocol.Key != "" && strings.EqualFold(protocol.Key, name)) {
+			return protocol, true
+		}
+		if stringContainsCaseIns(protocol.Name, name) {
+			partial = append(partial, protocol)
+		}
+	}
+	if len(partial) == 1 {
+		return partial[0], true
+	}
+	return data.Protocol{}, false
+}
+
+// protocolRounds returns every round recorded for the protocol named 'name',
+// newest first. Rounds recorded before dates were stored come last
+func protocolRounds(name string) ([]data.Round, error) {
+	var rounds []data.Round
+	err := store.View(func(tx storage.Tx) error {
+		keys, err := tx.Lookup(storage.RoundsBucket, storage.ProtocolIndex, name)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			var round data.Round
+			ok, err := tx.Get(storage.RoundsBucket, key, &round)
+			if err != nil {
+				return err
+			}
+			if ok {
+				rounds = append(rounds, round)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(rounds, func(i, j int) bool {
+		return rounds[i].Date > rounds[j].Date
+	})
+	return rounds, nil
+}
+
+// protocolEmbed builds the profile embed sent in response to the /protocol
+// command
+func protocolEmbed(protocol data.Protocol, rounds []data.Round) *discordgo.MessageEmbed {
+	var roundLines strings.Builder
+	for i, round := range rounds {
+		if i == protocolRoundsShown {
+			fmt.Fprintf(&roundLines, "and %d more", len(rounds)-protocolRoundsShown)
+			break
+		}
+		date := round.Date
+		if date == "" {
+			date = "N/A"
+		}
+		fmt.Fprintf(&roundLines, "%s | %s | %s | %s\n", date, round.Stage, round.Raise, round.Category)
+	}
+
+	twitter := protocol.TwitterURL
+	if protocol.TwitterHandle != "" {
+		twitter = fmt.Sprintf("[@%s](%s)", protocol.TwitterHandle, protocol.TwitterURL)
+	}
+
+	return &discordgo.MessageEmbed{
+		Title: protocol.Name,
+		URL:   protocol.Website,
+		Color: embedColor,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Twitter", Value: orNA(twitter), Inline: true},
+			{Name: "Website", Value: orNA(protocol.Website), Inline: true},
+			{Name: "Docs", Value: orNA(protocol.Docs), Inline: true},
+			{Name: "Discord", Value: orNA(protocol.Discord), Inline: true},
+			{Name: "GitHub", Value: orNA(protocol.GitHub), Inline: true},
+			{Name: "Telegram", Value: orNA(protocol.Telegram), Inline: true},
+			{Name: "Chains", Value: orNA(strings.Join(protocol.Chains, ", "))},
+			{Name: fmt.Sprintf("Funding Rounds (%d)", len(rounds)), Value: orNA(truncate(roundLines.String(), 1024))},
+		},
+	}
+}
+
+// protocolCommandHandler responds to the /protocol command with the profile
+// embed of the protocol passed as the 'name' option
+func protocolCommandHandler(s discordSession, i *discordgo.InteractionCreate) {
+	name := i.ApplicationCommandData().Options[0].StringValue()
+	protocol, ok := findProtocol(name)
+	if !ok {
+		interactionReply(s, i, "No protocol by that name exists, check spelling.")
+		return
+	}
+	rounds, err := protocolRounds(protocol.Name)
+	if err != nil {
+		log.Printf("reading rounds for %s | %v\n", protocol.Name, err)
+	}
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{protocolEmbed(protocol, rounds)},
+		},
+	})
+}
+
+// editProtocolCommandHandler responds to the /edit-protocol command by setting
+// the 'field' of the protocol 'name' to 'value'. Leaving out the value clears
+// the field. Chains are given comma separated
+func editProtocolCommandHandler(s discordSession, i *discordgo.InteractionCreate) {
+	var name, field, value string
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "name":
+			name = option.StringValue()
+		case "field":
+			field = option.StringValue()
+		case "value":
+			value = strings.TrimSpace(option.StringValue())
+		}
+	}
+	protocol, ok := findProtocol(name)
+	if !ok {
+		interactionReply(s, i, "No protocol by that name exists, check spelling.")
+		return
+	}
+
+	entry := memberAudit(i.Member, "edit-protocol")
+	switch field {
+	case "twitter":
+		changeTwitterURL(entry, protocol.Name, value)
+	case "chains":
+		previousChains := protocol.Chains
+		protocol.Chains = nil
+		for _, chain := range strings.Split(value, ",") {
+			if chain = strings.TrimSpace(chain); chain != "" {
+				protocol.Chains = append(protocol.Chains, chain)
+			}
+		}
+		saveProtocol(protocol)
+		entry.Protocol, entry.Setting = protocol.Name, field
+		entry.Old, entry.New = auditValue(previousChains), auditValue(protocol.Chains)
+		audit(entry)
+	default:
+		fieldValue, ok := protocolLinkFields[field]
+		if !ok {
+			interactionReply(s, i, fmt.Sprintf("Unknown field %s.", field))
+			return
+		}
+		entry.Protocol, entry.Setting, entry.Old = protocol.Name, field, auditValue(*fieldValue(&protocol))
+		*fieldValue(&protocol) = value
+		saveProtocol(protocol)
+		entry.New = auditValue(value)
+		audit(entry)
+	}
+	if value == "" {
+		interactionReply(s, i, fmt.Sprintf("Cleared %s of %s.", field, protocol.Name))
+		return
+	}
+	interactionReply(s, i, fmt.Sprintf("Set %s of %s to %s.", field, protocol.Name, value))
+}
+
+// saveProtocol stores 'protocol' in memory and marks protocols for backup
+func saveProtocol(protocol data.Protocol) {
+	if !protocol.New {
+		protocols.Modified = true
+	}
+	protocols.M[protocol.Name] = protocol
+}
+
+// ephemeralReply responds to an interaction with a plain text message only the
+// user who sent it can see
+func ephemeralReply(s discordSession, i *discordgo.InteractionCreate, content string) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:         content,
+			Flags:           discordgo.MessageFlagsEphemeral,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		},
+	})
+	if err != nil {
+		log.Println("responding to interaction |", err)
+	}
+}
+
+// interactionReply responds to an interaction with a plain text message
+func interactionReply(s discordSession, i *discordgo.InteractionCreate, content string) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:         content,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		},
+	})
+	if err != nil {
+		log.Println("responding to interaction |", err)
+	}
+}
@@ -0,0 +1,169 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+)
+
+// loadReports describes everything recovered while loading store files, it's
+// sent to the operators once the bot is connected
+var loadReports []string
+
+// recoverJsonlRecords reads the store file at 'fileName' along with the
+// <fileName>.backup and tmp_<fileName> files left behind by failed writes and
+// merges them, the most recently modified file wins for keys found in more
+// than one. Backup files always hold records that never made it into the store
+// file so they're merged however old they are. A tmp_ file older than the store
+// file is dropped without merging, it was never renamed over the store file
+// and the store file was written since. Malformed lines are moved to <fileName>.quarantine instead of
+// stopping the load and every file is migrated to the current schema version.
+// If anything was recovered the merged records are written back and the
+// leftover files removed. With config.StrictLoad malformed lines or leftover
+// files are an error and nothing is changed on disk. Nothing is changed on
+// disk either while storeInMemory is set, the recovered records are only
+// returned
+func recoverJsonlRecords(fileName string) (map[string]json.RawMessage, error) {
+	sources, stale, err := jsonlSources(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	records := map[string]json.RawMessage{}
+	leftovers := append([]string{}, stale...)
+	var recovered, malformed []string
+	for _, source := range stale {
+		recovered = append(recovered, fmt.Sprintf("dropped %s, older than the file", source))
+	}
+	mainVersion := schemaVersion(fileName)
+	for _, source := range sources {
+		sourceRecords, version, bad, err := readJsonlRecords(source)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s | %w", source, err)
+		}
+		err = migrateRecords(fileName, version, sourceRecords)
+		if err != nil {
+			return nil, err
+		}
+		if source == fileName {
+			mainVersion = version
+		} else {
+			leftovers = append(leftovers, source)
+			recovered = append(recovered, fmt.Sprintf("%d keys from %s", len(sourceRecords), source))
+		}
+		if len(bad) > 0 {
+			malformed = append(malformed, bad...)
+			log.Printf("%d malformed lines in %s\n", len(bad), source)
+		}
+		for k, v := range sourceRecords {
+			records[k] = v
+		}
+	}
+
+	if conf().StrictLoad && (len(leftovers) > 0 || len(malformed) > 0) {
+		return nil, fmt.Errorf("strict load refusing %s with %d malformed lines and leftover files %v", fileName, len(malformed), leftovers)
+	}
+	if mainVersion == schemaVersion(fileName) && len(malformed) == 0 && len(recovered) == 0 {
+		return records, nil
+	}
+	if storeInMemory {
+		log.Printf("recovered %s in memory only, the files are left as they are | schema version %d, %d malformed lines, %s\n", fileName, mainVersion, len(malformed), strings.Join(recovered, ", "))
+		return records, nil
+	}
+	var report []string
+	if mainVersion < schemaVersion(fileName) {
+		backupFileName, err := backupOldSchema(fileName, mainVersion)
+		if err != nil {
+			return nil, err
+		}
+		report = append(report, fmt.Sprintf("migrated from schema version %d, original kept at %s", mainVersion, backupFileName))
+	}
+	if len(malformed) > 0 {
+		quarantineFileName, err := quarantineLines(fileName, malformed)
+		if err != nil {
+			return nil, err
+		}
+		report = append(report, fmt.Sprintf("moved %d malformed lines to %s", len(malformed), quarantineFileName))
+	}
+	report = append(report, recovered...)
+
+	err = writeJsonlFile(fileName, records)
+	if err != nil {
+		return nil, fmt.Errorf("writing recovered file %s | %w", fileName, err)
+	}
+	for _, leftover := range leftovers {
+		// tmp_ files are renamed over the main file by a successful overwrite
+		err = os.Remove(leftover)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("removing merged file %s | %v\n", leftover, err)
+		}
+	}
+	loadReports = append(loadReports, fmt.Sprintf("%s: %s", fileName, strings.Join(report, ", ")))
+	log.Printf("recovered %s | %s\n", fileName, strings.Join(report, ", "))
+	return records, nil
+}
+
+// jsonlSources returns 'fileName', its backup file if there is one and its
+// temporary file if it's newer than 'fileName', oldest first, along with the
+// temporary file if it isn't newer
+func jsonlSources(fileName string) ([]string, []string, error) {
+	type source struct {
+		name    string
+		modTime time.Time
+	}
+	var sources []source
+	var mainModTime time.Time
+	for _, name := range []string{fileName, fileName + ".backup", tmpFileName(fileName)} {
+		info, err := os.Stat(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			if name == fileName {
+				// read creates the main file, or reads it as empty in memory
+				sources = append(sources, source{name: name})
+			}
+			continue
+		}
+		if err != nil {
+			return nil, nil, data.ReadWriteFileError{OriginalErr: err}
+		}
+		if name == fileName {
+			mainModTime = info.ModTime()
+		}
+		sources = append(sources, source{name: name, modTime: info.ModTime()})
+	}
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].modTime.Before(sources[j].modTime)
+	})
+	var names, stale []string
+	for _, source := range sources {
+		if source.name == tmpFileName(fileName) && !source.modTime.After(mainModTime) {
+			stale = append(stale, source.name)
+			continue
+		}
+		names = append(names, source.name)
+	}
+	return names, stale, nil
+}
+
+// quarantineLines appends 'lines' to <fileName>.quarantine so they can be
+// fixed by hand and returns the quarantine file's name
+func quarantineLines(fileName string, lines []string) (string, error) {
+	quarantineFileName := fileName + ".quarantine"
+	file, err := os.OpenFile(quarantineFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return "", data.ReadWriteFileError{OriginalErr: err}
+	}
+	defer file.Close()
+	_, err = file.WriteString(strings.Join(lines, "\n") + "\n")
+	if err != nil {
+		return "", data.ReadWriteFileError{OriginalErr: err}
+	}
+	return quarantineFileName, nil
+}
@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/readysetliqd/airdrop-discord-bot-go/config"
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+)
+
+// offline is set while replaying, nothing is fetched from cryptorank, google
+// or protocol websites
+var offline bool
+
+// errOffline is returned for requests that can't be made while replaying
+var errOffline = errors.New("not available while replaying offline")
+
+// archivePath returns where the cryptorank response for the rounds from
+// 'start' to 'end' fetched at 'fetchedAt' is archived. Responses are kept in
+// a directory per day and sort by the time they were fetched
+func archivePath(start, end string, fetchedAt time.Time) string {
+	dir := conf().ArchiveDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(conf().DataDir, dir)
+	}
+	fetchedAt = fetchedAt.UTC()
+	return filepath.Join(dir, fetchedAt.Format("2006-01-02"), fmt.Sprintf("%s_%s_%s.json", fetchedAt.Format("150405.000"), start, end))
+}
+
+// archiveResponse keeps the raw cryptorank response 'msg' for the rounds from
+// 'start' to 'end' in config.ArchiveDir for Replay. Errors are logged, a
+// missing archive doesn't stop the daily job
+func archiveResponse(start, end string, msg []byte) {
+	if conf().ArchiveDir == "" {
+		return
+	}
+	fileName := archivePath(start, end, time.Now())
+	err := os.MkdirAll(filepath.Dir(fileName), 0777)
+	if err == nil {
+		err = os.WriteFile(fileName, msg, 0666)
+	}
+	if err != nil {
+		log.Printf("archiving cryptorank response to %s | %v\n", fileName, data.ReadWriteFileError{OriginalErr: err})
+	}
+}
+
+// archivedRange returns the start and end dates of the rounds in the archived
+// response 'fileName', taken from its name. Responses archived some other way
+// use the earliest and latest round dates
+func archivedRange(fileName string, entries []data.RespData) (string, string) {
+	parts := strings.Split(strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName)), "_")
+	if len(parts) == 3 {
+		_, startErr := time.Parse("2006-01-02", parts[1])
+		_, endErr := time.Parse("2006-01-02", parts[2])
+		if startErr == nil && endErr == nil {
+			return parts[1], parts[2]
+		}
+	}
+	var earliest, latest time.Time
+	for _, entry := range entries {
+		if earliest.IsZero() || entry.Date.Before(earliest) {
+			earliest = entry.Date
+		}
+		if entry.Date.After(latest) {
+			latest = entry.Date
+		}
+	}
+	return earliest.Format("2006-01-02"), latest.Format("2006-01-02")
+}
+
+// archivedFiles returns the .json files at 'path' in the order they were
+// archived, or 'path' itself when it's a file
+func archivedFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, data.ReadWriteFileError{OriginalErr: err}
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	var files []string
+	err = filepath.WalkDir(path, func(fileName string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && filepath.Ext(fileName) == ".json" {
+			files = append(files, fileName)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, data.ReadWriteFileError{OriginalErr: err}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Replay sends the cryptorank responses archived at 'path', a file or a
+// directory of them, through the recap and round embeds without connecting to
+// discord or the network. Everything the bot would post, react to or start is
+// written to stdout as json lines like the shadow log, with made up IDs and
+// times so replays of the same archive can be compared. Stored data is read
+// but nothing is written back
+func Replay(cfg *config.Config, path string) error {
+	files, err := archivedFiles(path)
+	if err != nil {
+		return fmt.Errorf("reading archive %s | %w", path, err)
+	}
+	err = openStores(cfg, true)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	offline = true
+	shadow := &shadowSession{
+		log:      os.Stdout,
+		origins:  map[string]string{},
+		messages: map[string]*discordgo.Message{},
+		now:      func() time.Time { return time.UnixMilli(discordEpoch) },
+	}
+	s = shadow
+	var errs []error
+	for _, fileName := range files {
+		msg, err := os.ReadFile(fileName)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("reading %s | %w", fileName, data.ReadWriteFileError{OriginalErr: err}))
+			continue
+		}
+		respDataStructs, err := decodeCryptoRank(msg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("decoding %s | %w", fileName, err))
+			continue
+		}
+		shadow.mu.Lock()
+		shadow.record("replay", "", "", fileName)
+		shadow.mu.Unlock()
+		start, end := archivedRange(fileName, *respDataStructs)
+		err = sendFundingRoundsRecapEmbed(respDataStructs, start, end, "")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("replaying recap of %s | %w", fileName, err))
+		}
+		_, err = sendIndividualFundingRoundsEmbeds(respDataStructs, "")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("replaying rounds of %s | %w", fileName, err))
+		}
+		log.Printf("replayed %d rounds from %s\n", len(*respDataStructs), fileName)
+	}
+	return errors.Join(errs...)
+}
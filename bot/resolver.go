@@ -0,0 +1,295 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+)
+
+const (
+	// resolveTimeout is how long the whole resolver chain gets for a protocol
+	resolveTimeout = time.Second * 30
+	// maxWebsiteBytes is how much of a project's website is read when looking
+	// for twitter links
+	maxWebsiteBytes = 1 << 20
+)
+
+// HandleResolver finds candidates for a protocol's twitter account. Resolvers
+// are tried in order by resolveHandle, a resolver with nothing to offer returns
+// no candidates and no error
+type HandleResolver interface {
+	Name() string
+	Resolve(ctx context.Context, q *HandleQuery) ([]HandleCandidate, error)
+}
+
+// HandleCandidate is a possible twitter account for a protocol. Confidence is
+// from 0 to 1, candidates at or above config.HandleConfidence are stored
+// without asking an operator
+type HandleCandidate struct {
+	URL        string
+	Title      string
+	Snippet    string
+	Confidence float64
+	Source     string
+}
+
+// HandleQuery is the protocol being resolved. The cryptorank project is
+// fetched at most once and shared between resolvers
+type HandleQuery struct {
+	Name   string
+	Symbol string
+	Key    string
+
+	project    *data.ProjectResp
+	projectErr error
+}
+
+// newHandleQuery builds a query for the protocol of a funding round
+func newHandleQuery(entry data.RespData) *HandleQuery {
+	sym, _ := entry.Symbol.(string)
+	return &HandleQuery{Name: entry.Name, Symbol: sym, Key: entry.Key}
+}
+
+// Project returns the protocol's cryptorank project, or nil if the protocol
+// has no cryptorank key or the bot is replaying offline
+func (q *HandleQuery) Project(ctx context.Context) (*data.ProjectResp, error) {
+	if q.Key == "" || offline {
+		return nil, nil
+	}
+	if q.project == nil && q.projectErr == nil {
+		q.project, q.projectErr = fetchCryptoRankProject(ctx, q.Key)
+	}
+	return q.project, q.projectErr
+}
+
+// Link returns the first link of type 'linkType' on the protocol's cryptorank
+// project page, or an empty string if there is none
+func (q *HandleQuery) Link(ctx context.Context, linkType string) (string, error) {
+	project, err := q.Project(ctx)
+	if err != nil || project == nil {
+		return "", err
+	}
+	for _, link := range project.Data.Links {
+		if strings.EqualFold(link.Type, linkType) && link.Value != "" {
+			return link.Value, nil
+		}
+	}
+	return "", nil
+}
+
+// handleResolvers is the resolver chain in the order resolvers are tried
+var handleResolvers = []HandleResolver{
+	cryptoRankResolver{},
+	curatedResolver{},
+	websiteResolver{},
+	googleResolver{},
+}
+
+// resolveHandle runs the resolver chain for 'q' and stops at the first
+// candidate with at least config.HandleConfidence. If none is confident
+// enough it returns the best candidate found and false. Candidate urls are
+// normalized and candidates that aren't twitter profiles are dropped. Resolver
+// errors are logged and the next resolver is tried
+func resolveHandle(ctx context.Context, q *HandleQuery) (HandleCandidate, bool) {
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	var best HandleCandidate
+	for _, resolver := range handleResolvers {
+		candidates, err := resolver.Resolve(ctx, q)
+		if err != nil {
+			log.Printf("resolving twitter for %s with %s | %v\n", q.Name, resolver.Name(), err)
+			continue
+		}
+		for _, candidate := range candidates {
+			profileURL, _, ok := normalizeTwitterURL(candidate.URL)
+			if !ok {
+				continue
+			}
+			candidate.URL = profileURL
+			candidate.Source = resolver.Name()
+			if candidate.Confidence > best.Confidence {
+				best = candidate
+			}
+		}
+		if best.Confidence >= conf().HandleConfidence {
+			return best, true
+		}
+	}
+	return best, false
+}
+
+// setTwitterURL stores 'twitterURL' normalized to its profile url along with
+// the handle for the protocol named 'name' and marks protocols for backup. An
+// empty url clears the protocol's twitter, a url that isn't a twitter profile
+// is stored as is without a handle
+func setTwitterURL(name, twitterURL string) {
+	protocol := protocols.M[name]
+	protocol.Name = name
+	protocol.TwitterURL, protocol.TwitterHandle = twitterURL, ""
+	if profileURL, handle, ok := normalizeTwitterURL(twitterURL); ok {
+		protocol.TwitterURL, protocol.TwitterHandle = profileURL, handle
+	} else if twitterURL != "" {
+		log.Printf("storing twitter url %s for %s as is, it isn't a twitter profile\n", twitterURL, name)
+	}
+	if !protocol.New {
+		protocols.Modified = true
+	}
+	protocols.M[name] = protocol
+}
+
+// cryptoRankResolver uses the twitter link on the protocol's cryptorank page
+type cryptoRankResolver struct{}
+
+func (cryptoRankResolver) Name() string { return "cryptorank" }
+
+func (cryptoRankResolver) Resolve(ctx context.Context, q *HandleQuery) ([]HandleCandidate, error) {
+	link, err := q.Link(ctx, "twitter")
+	if err != nil || link == "" {
+		return nil, err
+	}
+	return []HandleCandidate{{URL: link, Title: q.Name, Confidence: 0.95}}, nil
+}
+
+// curatedResolver looks the protocol up by name or cryptorank key in the json
+// file at config.CuratedHandlesFile. The file is read on each lookup so edits
+// apply without a restart
+type curatedResolver struct{}
+
+func (curatedResolver) Name() string { return "curated" }
+
+func (curatedResolver) Resolve(ctx context.Context, q *HandleQuery) ([]HandleCandidate, error) {
+	if conf().CuratedHandlesFile == "" {
+		return nil, nil
+	}
+	file, err := os.ReadFile(conf().CuratedHandlesFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, data.ReadWriteFileError{OriginalErr: err}
+	}
+	handles := map[string]string{}
+	err = json.Unmarshal(file, &handles)
+	if err != nil {
+		return nil, data.JsonMarshalError{OriginalErr: err}
+	}
+	for name, link := range handles {
+		if strings.EqualFold(name, q.Name) || (q.Key != "" && strings.EqualFold(name, q.Key)) {
+			return []HandleCandidate{{URL: link, Title: q.Name, Confidence: 1}}, nil
+		}
+	}
+	return nil, nil
+}
+
+var (
+	// twitterMetaPattern matches the twitter:site and twitter:creator meta tags
+	// in either attribute order
+	twitterMetaPattern = regexp.MustCompile(`(?i)<meta[^>]+(?:name|property)=["']twitter:(?:site|creator)["'][^>]+content=["']@?([A-Za-z0-9_]{1,15})["']|<meta[^>]+content=["']@?([A-Za-z0-9_]{1,15})["'][^>]+(?:name|property)=["']twitter:(?:site|creator)["']`)
+	// twitterLinkPattern matches links to a twitter or x profile
+	twitterLinkPattern = regexp.MustCompile(`(?i)href=["'](?:https?:)?//(?:www\.|mobile\.)?(?:twitter|x)\.com/([A-Za-z0-9_]{1,15})(?:[/?#"'])`)
+)
+
+// websiteResolver reads the protocol's website, found through its cryptorank
+// page, and looks for twitter meta tags or links to a twitter profile
+type websiteResolver struct{}
+
+func (websiteResolver) Name() string { return "website" }
+
+func (websiteResolver) Resolve(ctx context.Context, q *HandleQuery) ([]HandleCandidate, error) {
+	website, err := q.Link(ctx, "web")
+	if err != nil || website == "" {
+		return nil, err
+	}
+	body, err := httpGet(ctx, website, maxWebsiteBytes)
+	if err != nil {
+		return nil, err
+	}
+	if match := twitterMetaPattern.FindStringSubmatch(body); match != nil {
+		handle := match[1] + match[2]
+		return []HandleCandidate{{URL: "https://x.com/" + handle, Title: q.Name, Snippet: website, Confidence: 0.85}}, nil
+	}
+	for _, match := range twitterLinkPattern.FindAllStringSubmatch(body, -1) {
+		if isReservedTwitterPath(match[1]) {
+			continue
+		}
+		return []HandleCandidate{{URL: "https://x.com/" + match[1], Title: q.Name, Snippet: website, Confidence: 0.7}}, nil
+	}
+	return nil, nil
+}
+
+// googleResolver offers the top cached Google custom search results rated by
+// how well their handle matches the protocol. It never searches itself, the
+// chain runs for every new round and would use up the search quota operators
+// need for the search command, so only protocols an operator already searched
+// for are resolved this way
+type googleResolver struct{}
+
+func (googleResolver) Name() string { return "google" }
+
+func (googleResolver) Resolve(ctx context.Context, q *HandleQuery) ([]HandleCandidate, error) {
+	resp, ok := cachedSearch(q.Name, 1)
+	if !ok {
+		return nil, nil
+	}
+	return searchCandidates(resp, q.Name, q.Symbol), nil
+}
+
+// isReservedTwitterPath returns true for the first path segments of twitter
+// urls that aren't profiles
+func isReservedTwitterPath(segment string) bool {
+	switch strings.ToLower(segment) {
+	case "i", "home", "search", "hashtag", "intent", "share", "explore", "settings", "login", "signup", "notifications", "messages", "tos", "privacy":
+		return true
+	}
+	return false
+}
+
+// fetchCryptoRankProject gets the cryptorank project with key 'key'
+func fetchCryptoRankProject(ctx context.Context, key string) (*data.ProjectResp, error) {
+	projectURL := fmt.Sprintf(data.ProjectURL, url.PathEscape(key))
+	body, err := httpGet(ctx, projectURL, maxWebsiteBytes)
+	if err != nil {
+		return nil, err
+	}
+	var project data.ProjectResp
+	err = json.Unmarshal([]byte(body), &project)
+	if err != nil {
+		return nil, data.JsonMarshalError{OriginalErr: err}
+	}
+	return &project, nil
+}
+
+// httpGet sends a GET request to 'rawURL' and returns at most 'limit' bytes of
+// the response body
+func httpGet(ctx context.Context, rawURL string, limit int64) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("building request to %s | %w", rawURL, err)
+	}
+	client := &http.Client{Timeout: data.RequestTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", data.HTTPRequestError{URL: rawURL, OriginalErr: err}
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, limit))
+	if err != nil {
+		return "", data.HTTPRequestError{URL: rawURL, OriginalErr: err}
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", data.HTTPStatusError{URL: rawURL, StatusCode: res.StatusCode, Body: truncate(string(body), 200)}
+	}
+	return string(body), nil
+}
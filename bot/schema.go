@@ -0,0 +1,186 @@
+package bot
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+)
+
+// maxJsonlLineBytes is the longest line read from a store file, lines of
+// unprocessed messages hold whole embeds
+const maxJsonlLineBytes = 16 << 20
+
+// migration upgrades the records of a store file by one schema version in
+// place. Records are keyed the same way as the lines of the file
+type migration func(records map[string]json.RawMessage) error
+
+// schemaMigrations is the migration registry. The migration at index i
+// upgrades a file from schema version i to i+1, so the current version of a
+// file is the number of migrations registered for it. Files written before
+// versioning have no header and are version 0. Any change to the stored types
+// that isn't just a new field must add a migration here. Files added after
+// versioning start at version 0 with no migrations
+var schemaMigrations = map[string][]migration{
+	data.ProtocolsFileName:           {migrateProtocolsV1},
+	data.UnprocessedMessagesFileName: {addSchemaHeader},
+	data.FundsFileName:               {addSchemaHeader},
+	data.RoundsFileName:              {addSchemaHeader},
+	data.SearchCacheFileName:         {addSchemaHeader},
+	data.SearchUsageFileName:         {addSchemaHeader},
+	data.SettingsFileName:            nil,
+	data.AuditFileName:               nil,
+}
+
+// schemaVersion returns the current schema version of the store file at
+// 'fileName'. Backup and temporary copies share the version of their file
+func schemaVersion(fileName string) int {
+	base := filepath.Base(fileName)
+	base = strings.TrimPrefix(base, "tmp_")
+	base = strings.TrimSuffix(base, ".backup")
+	return len(schemaMigrations[base])
+}
+
+// schemaHeader returns the header line written at the top of the store file at
+// 'fileName', including the trailing newline
+func schemaHeader(fileName string) (string, error) {
+	header, err := json.Marshal(map[string]int{data.SchemaKey: schemaVersion(fileName)})
+	if err != nil {
+		return "", data.JsonMarshalError{OriginalErr: err}
+	}
+	return string(header) + "\n", nil
+}
+
+// readJsonlRecords reads every line of the .jsonl file at 'fileName' without
+// decoding the values and returns them merged into one map along with the
+// schema version from the file's header and any lines that aren't valid json.
+// Later lines overwrite earlier ones with the same key. Files without a header
+// are version 0 unless they're empty, in which case there is nothing to
+// migrate and they're current. Missing files are created unless storeInMemory
+// is set, then they're read as empty
+func readJsonlRecords(fileName string) (map[string]json.RawMessage, int, []string, error) {
+	flag := os.O_CREATE | os.O_RDONLY
+	if storeInMemory {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(fileName, flag, 0666)
+	if storeInMemory && errors.Is(err, fs.ErrNotExist) {
+		return map[string]json.RawMessage{}, schemaVersion(fileName), nil, nil
+	}
+	if err != nil {
+		return nil, 0, nil, data.ReadWriteFileError{OriginalErr: err}
+	}
+	defer file.Close()
+	records := map[string]json.RawMessage{}
+	var malformed []string
+	version := -1
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxJsonlLineBytes)
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		line := map[string]json.RawMessage{}
+		err = json.Unmarshal([]byte(text), &line)
+		if err != nil {
+			malformed = append(malformed, text)
+			continue
+		}
+		if raw, ok := line[data.SchemaKey]; ok {
+			err = json.Unmarshal(raw, &version)
+			if err != nil {
+				malformed = append(malformed, text)
+				continue
+			}
+			delete(line, data.SchemaKey)
+		} else if version == -1 {
+			version = 0
+		}
+		for k, v := range line {
+			records[k] = v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, nil, data.ReadWriteFileError{OriginalErr: err}
+	}
+	if version == -1 {
+		version = schemaVersion(fileName)
+	}
+	return records, version, malformed, nil
+}
+
+// migrateRecords upgrades 'records' read from the store file at 'fileName'
+// from schema 'version' to the current version in memory. Returns
+// data.SchemaVersionError for files newer than this bot supports
+func migrateRecords(fileName string, version int, records map[string]json.RawMessage) error {
+	current := schemaVersion(fileName)
+	if version > current {
+		return data.SchemaVersionError{FileName: fileName, Version: version, Supported: current}
+	}
+	for v, migrate := range schemaMigrations[filepath.Base(fileName)][version:] {
+		err := migrate(records)
+		if err != nil {
+			return fmt.Errorf("migrating %s from schema version %d to %d | %w", fileName, version+v, version+v+1, err)
+		}
+	}
+	return nil
+}
+
+// backupOldSchema copies the store file at 'fileName' written with schema
+// 'version' to <fileName>.v<version>.bak before it's rewritten as the current
+// version and returns the backup's name
+func backupOldSchema(fileName string, version int) (string, error) {
+	backupFileName := fmt.Sprintf("%s.v%d.bak", fileName, version)
+	original, err := os.ReadFile(fileName)
+	if err != nil {
+		return "", data.ReadWriteFileError{OriginalErr: err}
+	}
+	err = os.WriteFile(backupFileName, original, 0666)
+	if err != nil {
+		return "", data.ReadWriteFileError{OriginalErr: err}
+	}
+	return backupFileName, nil
+}
+
+// addSchemaHeader is the migration for files whose records didn't change when
+// the schema header was introduced, writing the file back adds the header
+func addSchemaHeader(records map[string]json.RawMessage) error {
+	return nil
+}
+
+// migrateProtocolsV1 normalizes twitter urls stored before they were saved as
+// https://x.com/<handle> profiles and fills in their handles. Urls that can't
+// be turned into a profile are logged and left alone
+func migrateProtocolsV1(records map[string]json.RawMessage) error {
+	for name, raw := range records {
+		protocol := map[string]any{}
+		err := json.Unmarshal(raw, &protocol)
+		if err != nil {
+			return data.JsonMarshalError{OriginalErr: err}
+		}
+		twitterURL, _ := protocol["TwitterURL"].(string)
+		if twitterURL == "" {
+			continue
+		}
+		profileURL, handle, ok := normalizeTwitterURL(twitterURL)
+		if !ok {
+			log.Printf("stored twitter url %s for %s isn't a twitter profile, leaving it as is\n", twitterURL, name)
+			continue
+		}
+		protocol["TwitterURL"] = profileURL
+		protocol["TwitterHandle"] = handle
+		records[name], err = json.Marshal(protocol)
+		if err != nil {
+			return data.JsonMarshalError{OriginalErr: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package bot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/readysetliqd/airdrop-discord-bot-go/config"
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+)
+
+// schemaStores are the store files with a golden file in testdata/schema for
+// every schema version they were written with, named after the store file
+// with .v<version> before .jsonl
+var schemaStores = []string{
+	data.ProtocolsFileName,
+	data.RoundsFileName,
+	data.UnprocessedMessagesFileName,
+	data.FundsFileName,
+	data.SearchCacheFileName,
+	data.SearchUsageFileName,
+	data.SettingsFileName,
+	data.AuditFileName,
+}
+
+// goldenFile returns the path of the golden file of 'store' at schema 'version'
+func goldenFile(store string, version int) string {
+	ext := filepath.Ext(store)
+	return filepath.Join("testdata", "schema", fmt.Sprintf("%s.v%d%s", strings.TrimSuffix(store, ext), version, ext))
+}
+
+// useTempStore copies the golden file of 'store' at schema 'version' into a
+// temporary directory as the store file and returns its path and contents
+func useTempStore(t *testing.T, store string, version int) (string, []byte) {
+	t.Helper()
+	dir := t.TempDir()
+	currentConfig.Store(&config.Config{DataDir: dir})
+	golden, err := os.ReadFile(goldenFile(store, version))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(dir, store)
+	err = os.WriteFile(fileName, golden, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fileName, golden
+}
+
+// readStoreFile returns the first line of the .jsonl file at 'fileName' and
+// the records of the lines after it, decoded so they compare regardless of key
+// order and whitespace
+func readStoreFile(t *testing.T, fileName string) (string, map[string]any) {
+	t.Helper()
+	file, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var first string
+	records := map[string]any{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		if first == "" {
+			first = scanner.Text()
+			if strings.Contains(first, data.SchemaKey) {
+				continue
+			}
+		}
+		line := map[string]any{}
+		err = json.Unmarshal(scanner.Bytes(), &line)
+		if err != nil {
+			t.Fatalf("decoding %s | %v", fileName, err)
+		}
+		for k, v := range line {
+			records[k] = v
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return first, records
+}
+
+// decodeRecords decodes loaded records the same way as readStoreFile
+func decodeRecords(t *testing.T, records map[string]json.RawMessage) map[string]any {
+	t.Helper()
+	decoded := make(map[string]any, len(records))
+	for k, raw := range records {
+		var v any
+		err := json.Unmarshal(raw, &v)
+		if err != nil {
+			t.Fatalf("decoding record %s | %v", k, err)
+		}
+		decoded[k] = v
+	}
+	return decoded
+}
+
+func TestMigrateGoldenFiles(t *testing.T) {
+	for _, store := range schemaStores {
+		current := schemaVersion(store)
+		_, want := readStoreFile(t, goldenFile(store, current))
+		for version := 0; version < current; version++ {
+			t.Run(fmt.Sprintf("%s v%d", store, version), func(t *testing.T) {
+				fileName, original := useTempStore(t, store, version)
+				records, err := loadJsonlFile[json.RawMessage](fileName)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := decodeRecords(t, records); !reflect.DeepEqual(got, want) {
+					t.Errorf("migrated records\n got %v\nwant %v", got, want)
+				}
+
+				header, err := schemaHeader(fileName)
+				if err != nil {
+					t.Fatal(err)
+				}
+				first, written := readStoreFile(t, fileName)
+				if first+"\n" != header {
+					t.Errorf("written header is %q, want %q", first+"\n", header)
+				}
+				if !reflect.DeepEqual(written, want) {
+					t.Errorf("written records\n got %v\nwant %v", written, want)
+				}
+
+				backup, err := os.ReadFile(fmt.Sprintf("%s.v%d.bak", fileName, version))
+				if err != nil {
+					t.Fatalf("reading backup | %v", err)
+				}
+				if !bytes.Equal(backup, original) {
+					t.Errorf("backup doesn't match the v%d file\n got %s\nwant %s", version, backup, original)
+				}
+			})
+		}
+	}
+}
+
+func TestLoadCurrentGoldenFiles(t *testing.T) {
+	for _, store := range schemaStores {
+		t.Run(store, func(t *testing.T) {
+			current := schemaVersion(store)
+			fileName, original := useTempStore(t, store, current)
+			_, want := readStoreFile(t, fileName)
+			records, err := loadJsonlFile[json.RawMessage](fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := decodeRecords(t, records); !reflect.DeepEqual(got, want) {
+				t.Errorf("loaded records\n got %v\nwant %v", got, want)
+			}
+			after, err := os.ReadFile(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(after, original) {
+				t.Errorf("current version file was rewritten")
+			}
+			backups, err := filepath.Glob(fileName + ".v*.bak")
+			if err != nil || len(backups) > 0 {
+				t.Errorf("current version file was backed up to %v | %v", backups, err)
+			}
+		})
+	}
+}
+
+func TestLoadNewerSchema(t *testing.T) {
+	fileName, _ := useTempStore(t, data.ProtocolsFileName, schemaVersion(data.ProtocolsFileName))
+	err := os.WriteFile(fileName, []byte(`{"_schema":99}`+"\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadJsonlFile[json.RawMessage](fileName)
+	var versionErr data.SchemaVersionError
+	if !errors.As(err, &versionErr) || versionErr.Version != 99 {
+		t.Errorf("loading a newer schema returned %v, want data.SchemaVersionError", err)
+	}
+}
+
+func TestRecoverFailedAppend(t *testing.T) {
+	dir := t.TempDir()
+	currentConfig.Store(&config.Config{DataDir: dir})
+	fileName := filepath.Join(dir, data.RoundsFileName)
+
+	// appending fails while the store file can't be opened, the rounds go to
+	// the backup file
+	err := os.Mkdir(fileName, 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AppendToFile(fileName, map[string]data.Round{"1": {Name: "Aurora Labs"}})
+	if err == nil {
+		t.Fatal("appending to a directory succeeded")
+	}
+	err = os.Remove(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	err = os.Chtimes(fileName+".backup", old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the next append works and leaves the store file newer than the backup
+	err = AppendToFile(fileName, map[string]data.Round{"2": {Name: "Beacon"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rounds, err := loadJsonlFile[data.Round](fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rounds["1"].Name != "Aurora Labs" || rounds["2"].Name != "Beacon" {
+		t.Errorf("loaded rounds %v, want both the backed up and the appended round", rounds)
+	}
+	if _, err := os.Stat(fileName + ".backup"); !os.IsNotExist(err) {
+		t.Errorf("merged backup file wasn't removed | %v", err)
+	}
+	_, written := readStoreFile(t, fileName)
+	if len(written) != 2 {
+		t.Errorf("recovered file holds %v, want both rounds", written)
+	}
+}
+
+func TestDropStaleTmpFile(t *testing.T) {
+	fileName, _ := useTempStore(t, data.RoundsFileName, schemaVersion(data.RoundsFileName))
+	_, want := readStoreFile(t, fileName)
+	err := os.WriteFile(tmpFileName(fileName), []byte(`{"_schema":1}`+"\n"+`{"3":{"Name":"Deleted"}}`+"\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	err = os.Chtimes(tmpFileName(fileName), old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := loadJsonlFile[json.RawMessage](fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decodeRecords(t, records); !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded records\n got %v\nwant %v", got, want)
+	}
+	if _, err := os.Stat(tmpFileName(fileName)); !os.IsNotExist(err) {
+		t.Errorf("stale tmp file wasn't removed | %v", err)
+	}
+}
+
+func TestLoadInMemoryLeavesFiles(t *testing.T) {
+	storeInMemory = true
+	t.Cleanup(func() { storeInMemory = false })
+	fileName, original := useTempStore(t, data.ProtocolsFileName, 0)
+	_, want := readStoreFile(t, goldenFile(data.ProtocolsFileName, schemaVersion(data.ProtocolsFileName)))
+	backup := []byte(`{"Extra":{"Name":"Extra"}}` + "\n")
+	err := os.WriteFile(fileName+".backup", backup, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := loadJsonlFile[json.RawMessage](fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := decodeRecords(t, records)
+	if got["Extra"] == nil || !reflect.DeepEqual(got["Aurora Labs"], want["Aurora Labs"]) {
+		t.Errorf("loaded records %v, want the migrated records and the backup", got)
+	}
+	after, err := os.ReadFile(fileName)
+	if err != nil || !bytes.Equal(after, original) {
+		t.Errorf("store file was changed | %v", err)
+	}
+	if after, err := os.ReadFile(fileName + ".backup"); err != nil || !bytes.Equal(after, backup) {
+		t.Errorf("backup file was changed | %v", err)
+	}
+	if backups, _ := filepath.Glob(fileName + ".v*.bak"); len(backups) > 0 {
+		t.Errorf("migration backups %v were written", backups)
+	}
+
+	missing := filepath.Join(filepath.Dir(fileName), data.FundsFileName)
+	_, err = loadJsonlFile[json.RawMessage](missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("missing store file was created | %v", err)
+	}
+}
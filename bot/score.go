@@ -0,0 +1,68 @@
+package bot
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+)
+
+// scoreRound rates a funding round by its airdrop potential using the weights
+// in config.Scoring. Higher is better, the score has no upper bound
+func scoreRound(entry data.RespData) float64 {
+	weights := conf().Scoring
+	score := weights.RaiseWeight * logScale(float64(entry.Raise)/1000000)
+	score += weights.TotalRaiseWeight * logScale(float64(entry.TotalRaise)/1000000)
+
+	for _, fund := range entry.Funds {
+		switch fund.Tier {
+		case 1:
+			score += weights.Tier1FundWeight
+		case 2:
+			score += weights.Tier2FundWeight
+		}
+	}
+	score += weights.FundCountWeight * float64(len(entry.Funds))
+
+	// each top fund bonus counts once per round even if several of its
+	// entities (e.g. "Coinbase Ventures" and "Coinbase") took part
+	for name, bonus := range weights.TopFunds {
+		for _, fund := range entry.Funds {
+			if stringContainsCaseIns(fund.Name, name) {
+				score += bonus
+				break
+			}
+		}
+	}
+
+	score += weights.TwitterScoreWeight * float64(entry.TwitterScore)
+	score += weights.FollowersWeight * logScale(float64(entry.FollowersCount))
+
+	for category, bonus := range weights.Categories {
+		if strings.EqualFold(entry.Category.Name, category) {
+			score += bonus
+		}
+	}
+
+	if sym, ok := entry.Symbol.(string); !ok || sym == "" {
+		score += weights.NoTokenBonus
+	}
+	return score
+}
+
+// logScale returns log10(1+x) so zero values score zero and every order of
+// magnitude adds roughly one point
+func logScale(x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	return math.Log10(1 + x)
+}
+
+// sortRoundsByScore sorts 'rounds' in place from highest to lowest score
+func sortRoundsByScore(rounds []data.RespData) {
+	sort.SliceStable(rounds, func(i, j int) bool {
+		return scoreRound(rounds[i]) > scoreRound(rounds[j])
+	})
+}
@@ -0,0 +1,280 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+	"google.golang.org/api/customsearch/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+var (
+	// searchDisabledUntil is when Google search is turned back on after the
+	// daily quota was used up, zero while search is on
+	searchDisabledUntil time.Time
+	searchService       *customsearch.Service
+	searchCache         *data.SearchCache
+	searchUsage         *data.SearchUsage
+	// searchMu guards all of the above, reaction handlers can search at the
+	// same time as the daily job
+	searchMu sync.Mutex
+)
+
+// searchDisabled returns true and the time search turns back on while Google
+// search is off because the daily quota was used up
+func searchDisabled() (time.Time, bool) {
+	searchMu.Lock()
+	defer searchMu.Unlock()
+	return searchDisabledUntil, time.Now().Before(searchDisabledUntil)
+}
+
+// errShadowSearch is returned for searches that aren't cached in shadow mode,
+// the search quota is kept for the bot running normally
+var errShadowSearch = errors.New("google search is off in shadow mode, only cached results are shown")
+
+// cachedSearch returns the cached results of the google search for 'name'
+// starting at result number 'start' if they're younger than
+// config.SearchCacheHours
+func cachedSearch(name string, start int) (*customsearch.Search, bool) {
+	searchMu.Lock()
+	cached, ok := searchCache.M[searchCacheKey(name, start)]
+	searchMu.Unlock()
+	if !ok || time.Since(cached.FetchedAt) >= time.Duration(conf().SearchCacheHours)*time.Hour {
+		return nil, false
+	}
+	return cachedSearchResp(cached), true
+}
+
+// googleSearch queries Google custom search for three results for 'name'
+// starting at result number 'start'. Results younger than
+// config.SearchCacheHours are served from the cache without using quota.
+// Returns data.SearchQuotaError without searching while the daily quota is
+// used up, errOffline for results not cached while replaying and
+// errShadowSearch for results not cached in shadow mode
+func googleSearch(ctx context.Context, name string, start int) (*customsearch.Search, error) {
+	if resp, ok := cachedSearch(name, start); ok {
+		return resp, nil
+	}
+	if offline {
+		return nil, errOffline
+	}
+	if storeInMemory {
+		return nil, errShadowSearch
+	}
+
+	if resetAt, disabled := searchDisabled(); disabled {
+		return nil, data.SearchQuotaError{ResetAt: resetAt}
+	}
+	svc, err := checkSearchQuota(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := svc.Cse.List().Cx(os.Getenv("GOOGLE_CX")).Q(name).Start(int64(start)).Num(3).OrTerms("web3").OrTerms("crypto").Context(ctx).Do()
+	if err != nil {
+		return nil, searchError(err)
+	}
+	countSearch()
+
+	cached := data.CachedSearch{Query: name, Start: start, FetchedAt: time.Now()}
+	for _, item := range resp.Items {
+		cached.Results = append(cached.Results, data.SearchResult{Title: item.Title, FormattedURL: item.FormattedUrl, Snippet: item.Snippet})
+	}
+	searchMu.Lock()
+	searchCache.M[searchCacheKey(name, start)] = cached
+	searchCache.Modified = true
+	searchMu.Unlock()
+	return resp, nil
+}
+
+// checkSearchQuota returns the shared custom search service, creating it on
+// first use. If the day's searches already reached config.GoogleDailyQuota it
+// turns off search until the quota resets and returns data.SearchQuotaError
+// instead. The search isn't counted until it succeeds, see countSearch
+func checkSearchQuota(ctx context.Context) (*customsearch.Service, error) {
+	searchMu.Lock()
+	defer searchMu.Unlock()
+	today := quotaDay(time.Now())
+	if conf().GoogleDailyQuota > 0 && searchUsage.M[today] >= conf().GoogleDailyQuota {
+		searchDisabledUntil = nextQuotaReset(time.Now())
+		return nil, data.SearchQuotaError{ResetAt: searchDisabledUntil}
+	}
+	if searchService == nil {
+		svc, err := customsearch.NewService(ctx, option.WithAPIKey(os.Getenv("GOOGLE_API_KEY")))
+		if err != nil {
+			return nil, fmt.Errorf("creating custom search service | %w", err)
+		}
+		searchService = svc
+	}
+	return searchService, nil
+}
+
+// countSearch counts a successful search against today's quota
+func countSearch() {
+	searchMu.Lock()
+	defer searchMu.Unlock()
+	searchUsage.M[quotaDay(time.Now())]++
+	searchUsage.Modified = true
+}
+
+// searchQuotaStatus returns the number of searches made today and when the
+// quota resets
+func searchQuotaStatus() (used int, resetAt time.Time) {
+	searchMu.Lock()
+	defer searchMu.Unlock()
+	now := time.Now()
+	return searchUsage.M[quotaDay(now)], nextQuotaReset(now)
+}
+
+// searchCacheKey is the key of the search cache entry for 'query' starting at
+// result number 'start'
+func searchCacheKey(query string, start int) string {
+	return fmt.Sprintf("%s|%d", query, start)
+}
+
+// cachedSearchResp rebuilds a custom search response from a cache entry with
+// the fields the bot uses
+func cachedSearchResp(cached data.CachedSearch) *customsearch.Search {
+	resp := &customsearch.Search{}
+	for _, result := range cached.Results {
+		resp.Items = append(resp.Items, &customsearch.Result{Title: result.Title, FormattedUrl: result.FormattedURL, Snippet: result.Snippet})
+	}
+	return resp
+}
+
+// pruneSearchCache drops cache entries older than config.SearchCacheHours and
+// usage counts from before yesterday
+func pruneSearchCache() {
+	searchMu.Lock()
+	defer searchMu.Unlock()
+	maxAge := time.Duration(conf().SearchCacheHours) * time.Hour
+	for key, cached := range searchCache.M {
+		if time.Since(cached.FetchedAt) >= maxAge {
+			delete(searchCache.M, key)
+			searchCache.Modified = true
+		}
+	}
+	yesterday := quotaDay(time.Now().AddDate(0, 0, -1))
+	for day := range searchUsage.M {
+		if day < yesterday {
+			delete(searchUsage.M, day)
+			searchUsage.Modified = true
+		}
+	}
+}
+
+// searchError converts an error from a custom search query. A daily quota error
+// turns off searching until the quota resets and is returned as a
+// data.SearchQuotaError, any other error is wrapped as is
+func searchError(err error) error {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || !isDailyQuotaError(apiErr) {
+		return fmt.Errorf("doing search | %w", err)
+	}
+	searchMu.Lock()
+	searchDisabledUntil = nextQuotaReset(time.Now())
+	resetAt := searchDisabledUntil
+	searchMu.Unlock()
+	log.Printf("daily search quota used up, turning search off until %v\n", resetAt)
+	return data.SearchQuotaError{ResetAt: resetAt, OriginalErr: err}
+}
+
+// isDailyQuotaError returns true if a Google API error says the daily query
+// quota is used up. Short bursts over the per minute limit are not treated as
+// daily quota errors
+func isDailyQuotaError(apiErr *googleapi.Error) bool {
+	if apiErr.Code != http.StatusTooManyRequests && apiErr.Code != http.StatusForbidden {
+		return false
+	}
+	for _, item := range apiErr.Errors {
+		if item.Reason == "dailyLimitExceeded" {
+			return true
+		}
+	}
+	return stringContainsCaseIns(apiErr.Message, "per day") || stringContainsCaseIns(apiErr.Body, "per day")
+}
+
+// nextQuotaReset returns the next midnight in Pacific time after 't', which is
+// when Google resets daily API quotas
+func nextQuotaReset(t time.Time) time.Time {
+	local := t.In(quotaLocation())
+	return time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, local.Location())
+}
+
+// quotaDay returns the date of 't' in Pacific time, the day its search counts
+// towards
+func quotaDay(t time.Time) string {
+	return t.In(quotaLocation()).Format("2006-01-02")
+}
+
+// quotaLocation returns the Pacific time zone Google's quotas reset in
+func quotaLocation() *time.Location {
+	pacific, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		// no tz database on this system, standard time is close enough
+		return time.FixedZone("PST", -8*60*60)
+	}
+	return pacific
+}
+
+// searchQuotaCommandHandler responds to the /search-quota command with the
+// number of Google searches used and left today
+func searchQuotaCommandHandler(s discordSession, i *discordgo.InteractionCreate) {
+	used, resetAt := searchQuotaStatus()
+	// a quota of 0 doesn't limit searches
+	remaining := "unlimited"
+	if quota := conf().GoogleDailyQuota; quota > 0 {
+		remaining = fmt.Sprintf("%d of %d", max(quota-used, 0), quota)
+	}
+	status := "On"
+	if disabledUntil, disabled := searchDisabled(); disabled {
+		status = fmt.Sprintf("Off until <t:%d:f>", disabledUntil.Unix())
+	}
+	searchMu.Lock()
+	cached := len(searchCache.M)
+	searchMu.Unlock()
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{{
+				Title: "Google Search Quota",
+				Color: 8421504,
+				Fields: []*discordgo.MessageEmbedField{
+					{Name: "Used Today", Value: fmt.Sprint(used), Inline: true},
+					{Name: "Remaining", Value: remaining, Inline: true},
+					{Name: "Resets", Value: fmt.Sprintf("<t:%d:R>", resetAt.Unix()), Inline: true},
+					{Name: "Search", Value: status, Inline: true},
+					{Name: "Cached Searches", Value: fmt.Sprint(cached), Inline: true},
+				},
+			}},
+		},
+	})
+}
+
+// sendSearchErrorEmbed reports a failed Google search for the protocol 'name'
+// to the channel 'channelID'
+func sendSearchErrorEmbed(s discordSession, channelID, name string, err error) {
+	log.Printf("searching for %s | %v\n", name, err)
+	description := fmt.Sprintf("Searching for %s failed, react again to retry.\n%s", name, truncate(err.Error(), 1000))
+	var quotaErr data.SearchQuotaError
+	if errors.As(err, &quotaErr) {
+		description = fmt.Sprintf("The daily Google search quota is used up, searching is off until <t:%d:f>.", quotaErr.ResetAt.Unix())
+	}
+	_, sendErr := s.ChannelMessageSendEmbed(channelID, &discordgo.MessageEmbed{
+		Title:       "Google search failed",
+		Description: strings.TrimSpace(description),
+		Color:       alertColor,
+	})
+	if sendErr != nil {
+		log.Printf("sending search error to channel %s | %v\n", channelID, sendErr)
+	}
+}
@@ -0,0 +1,332 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/readysetliqd/airdrop-discord-bot-go/config"
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+	"github.com/readysetliqd/airdrop-discord-bot-go/storage"
+)
+
+// configSetting is a config setting changed with /config, Key is its json name
+// in config.json
+type configSetting struct {
+	Key   string
+	Value func(c *config.Config) *string
+}
+
+// configChannels are the channels /config set-channel can change, by the name
+// used for them in the command
+var configChannels = map[string]configSetting{
+	"announcements": {"channelID", func(c *config.Config) *string { return &c.DefaultChannelID }},
+	"alerts":        {"alertChannelID", func(c *config.Config) *string { return &c.AlertChannelID }},
+	"forum":         {"forumChannelID", func(c *config.Config) *string { return &c.ForumChannelID }},
+}
+
+// configRoles are the roles /config set-role can change, by the name used for
+// them in the command
+var configRoles = map[string]configSetting{
+	"operator": {"botOperatorRoleID", func(c *config.Config) *string { return &c.BotOperatorRoleID }},
+	"funding":  {"fundingRoundRoleID", func(c *config.Config) *string { return &c.FundingRoundRoleID }},
+}
+
+// minPingRuleTier is the lowest fund tier /config add-rule accepts, leaving the
+// tier out matches any tier
+var minPingRuleTier = 1.0
+
+// settingChoices returns the command choices for 'settings' sorted by name
+func settingChoices(settings map[string]configSetting) []*discordgo.ApplicationCommandOptionChoice {
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(settings))
+	for name := range settings {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: name})
+	}
+	sort.Slice(choices, func(i, j int) bool {
+		return choices[i].Name < choices[j].Name
+	})
+	return choices
+}
+
+// loadSettings returns the settings changed with /config as json by their name
+// in config.json
+func loadSettings() (map[string]json.RawMessage, error) {
+	settings := map[string]json.RawMessage{}
+	err := store.View(func(tx storage.Tx) error {
+		return tx.ForEach(storage.SettingsBucket, func(key string, raw json.RawMessage) error {
+			settings[key] = raw
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading bucket %s | %w", storage.SettingsBucket, err)
+	}
+	return settings, nil
+}
+
+// applySettings returns a copy of 'base' with 'settings' decoded over it, as
+// if they had been set in config.json. The result is validated
+func applySettings(base *config.Config, settings map[string]json.RawMessage) (*config.Config, error) {
+	next := *base
+	// decoding into a slice reuses its array, copy it so 'base' isn't changed
+	next.PingRules = append([]config.PingRule(nil), base.PingRules...)
+	if len(settings) == 0 {
+		return &next, nil
+	}
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return nil, data.JsonMarshalError{OriginalErr: err}
+	}
+	err = json.Unmarshal(raw, &next)
+	if err != nil {
+		return nil, data.JsonMarshalError{OriginalErr: err}
+	}
+	err = next.Validate(nil)
+	if err != nil {
+		return nil, fmt.Errorf("settings changed with /config | %w", err)
+	}
+	return &next, nil
+}
+
+// withSettings returns 'base' with the stored settings changed with /config
+// applied over it
+func withSettings(base *config.Config) (*config.Config, error) {
+	settings, err := loadSettings()
+	if err != nil {
+		return nil, err
+	}
+	return applySettings(base, settings)
+}
+
+// changeSetting sets the config setting 'key' to 'value', or back to its value
+// in config.json when 'value' is nil, and puts the new config in use. 'get'
+// returns the setting from a config, its value before and after is recorded in
+// the audit trail as changed by 'member' with the command 'action'. The change
+// is validated first, an invalid one is not stored
+func changeSetting(member *discordgo.Member, action, key string, value any, get func(c *config.Config) any) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+	settings, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		delete(settings, key)
+	} else {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return data.JsonMarshalError{OriginalErr: err}
+		}
+		settings[key] = raw
+	}
+	base := baseConfig.Load()
+	next, err := applySettings(base, settings)
+	if err != nil {
+		return err
+	}
+
+	current := conf()
+	err = store.Update(func(tx storage.Tx) error {
+		var err error
+		if value == nil {
+			err = tx.Delete(storage.SettingsBucket, key)
+		} else {
+			err = tx.Put(storage.SettingsBucket, key, value)
+		}
+		if err != nil {
+			return err
+		}
+		entry := memberAudit(member, action)
+		entry.Setting, entry.Old, entry.New = key, auditValue(get(current)), auditValue(get(next))
+		return recordAudit(tx, entry)
+	})
+	if err != nil {
+		return fmt.Errorf("storing setting %s | %w", key, err)
+	}
+	swapConfig(base, next)
+	log.Printf("%s changed with /config, now %s\n", key, auditValue(get(next)))
+	return nil
+}
+
+// configCommandHandler responds to the /config command and its subcommands
+func configCommandHandler(s discordSession, i *discordgo.InteractionCreate) {
+	subcommand := i.ApplicationCommandData().Options[0]
+	options := map[string]*discordgo.ApplicationCommandInteractionDataOption{}
+	for _, option := range subcommand.Options {
+		options[option.Name] = option
+	}
+	action := "config " + subcommand.Name
+
+	var content string
+	var err error
+	switch subcommand.Name {
+	case "view":
+		configViewReply(s, i)
+		return
+	case "set-channel":
+		content, err = setIDSetting(i.Member, action, configChannels[options["setting"].StringValue()], options["channel"], "<#%s>")
+	case "set-role":
+		content, err = setIDSetting(i.Member, action, configRoles[options["setting"].StringValue()], options["role"], "<@&%s>")
+	case "add-rule":
+		rule := config.PingRule{RoleID: optionID(options["role"])}
+		if option, ok := options["fund"]; ok {
+			rule.FundName = strings.TrimSpace(option.StringValue())
+		}
+		if option, ok := options["tier"]; ok {
+			rule.FundTier = int(option.IntValue())
+		}
+		if option, ok := options["stage"]; ok {
+			rule.Stage = strings.TrimSpace(option.StringValue())
+		}
+		rules := append(append([]config.PingRule{}, conf().PingRules...), rule)
+		err = changeSetting(i.Member, action, "pingRules", rules, pingRulesSetting)
+		content = fmt.Sprintf("Added ping rule %d, %s.", len(rules), describePingRule(rule))
+	case "remove-rule":
+		number := int(options["number"].IntValue())
+		current := conf().PingRules
+		if number < 1 || number > len(current) {
+			content = fmt.Sprintf("There is no ping rule %d, /config view lists them.", number)
+			break
+		}
+		rules := append(append([]config.PingRule{}, current[:number-1]...), current[number:]...)
+		err = changeSetting(i.Member, action, "pingRules", rules, pingRulesSetting)
+		content = fmt.Sprintf("Removed ping rule %d, %s.", number, describePingRule(current[number-1]))
+	case "set-schedule":
+		var value any
+		if option, ok := options["time"]; ok {
+			value = strings.TrimSpace(option.StringValue())
+		}
+		err = changeSetting(i.Member, action, "schedule", value, func(c *config.Config) any { return c.Schedule })
+		content = fmt.Sprintf("The daily job now runs %s.", describeSchedule(conf().Schedule))
+	default:
+		content = fmt.Sprintf("Unknown subcommand %s.", subcommand.Name)
+	}
+	if err != nil {
+		log.Printf("%s | %v\n", action, err)
+		content = fmt.Sprintf("Config not changed.\n```\n%s\n```", truncate(err.Error(), 1800))
+	}
+	interactionReply(s, i, content)
+}
+
+// setIDSetting sets the channel or role 'setting' to the ID in 'option', or
+// back to its value in config.json when the option was left out. 'mention'
+// formats the ID for the reply
+func setIDSetting(member *discordgo.Member, action string, setting configSetting, option *discordgo.ApplicationCommandInteractionDataOption, mention string) (string, error) {
+	if setting.Value == nil {
+		return "", errors.New("unknown setting")
+	}
+	var value any
+	if option != nil {
+		value = optionID(option)
+	}
+	err := changeSetting(member, action, setting.Key, value, func(c *config.Config) any { return *setting.Value(c) })
+	if err != nil {
+		return "", err
+	}
+	id := *setting.Value(conf())
+	if option == nil {
+		if id == "" {
+			return fmt.Sprintf("Set %s back to config.json where it isn't set.", setting.Key), nil
+		}
+		return fmt.Sprintf("Set %s back to %s from config.json.", setting.Key, fmt.Sprintf(mention, id)), nil
+	}
+	return fmt.Sprintf("Set %s to %s.", setting.Key, fmt.Sprintf(mention, id)), nil
+}
+
+// optionID returns the ID picked in a channel, role or user option
+func optionID(option *discordgo.ApplicationCommandInteractionDataOption) string {
+	id, _ := option.Value.(string)
+	return id
+}
+
+// pingRulesSetting returns the ping rules of 'c' for changeSetting
+func pingRulesSetting(c *config.Config) any {
+	return c.PingRules
+}
+
+// configViewReply responds to /config view with the settings in use. Settings
+// changed with /config are marked
+func configViewReply(s discordSession, i *discordgo.InteractionCreate) {
+	c := conf()
+	settings, err := loadSettings()
+	if err != nil {
+		log.Println("config view |", err)
+	}
+	mark := func(key, value string) string {
+		if _, ok := settings[key]; ok {
+			return value + " (set with /config)"
+		}
+		return value
+	}
+	var fields []*discordgo.MessageEmbedField
+	for _, name := range []string{"announcements", "alerts", "forum"} {
+		setting := configChannels[name]
+		value := "N/A"
+		if id := *setting.Value(c); id != "" {
+			value = fmt.Sprintf("<#%s>", id)
+		}
+		fields = append(fields, &discordgo.MessageEmbedField{Name: name + " channel", Value: mark(setting.Key, value), Inline: true})
+	}
+	for _, name := range []string{"operator", "funding"} {
+		setting := configRoles[name]
+		value := "N/A"
+		if id := *setting.Value(c); id != "" {
+			value = config.RoleMention(id)
+		}
+		fields = append(fields, &discordgo.MessageEmbedField{Name: name + " role", Value: mark(setting.Key, value), Inline: true})
+	}
+	fields = append(fields, &discordgo.MessageEmbedField{Name: "Schedule", Value: mark("schedule", describeSchedule(c.Schedule)), Inline: true})
+
+	var rules strings.Builder
+	for n, rule := range c.PingRules {
+		fmt.Fprintf(&rules, "%d. %s\n", n+1, describePingRule(rule))
+	}
+	name := "Ping rules"
+	if _, ok := settings["pingRules"]; ok {
+		name += " (set with /config)"
+	}
+	fields = append(fields, &discordgo.MessageEmbedField{Name: name, Value: orNA(truncate(rules.String(), 1024))})
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{{
+				Title:  "Config",
+				Color:  embedColor,
+				Fields: fields,
+			}},
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		},
+	})
+	if err != nil {
+		log.Println("responding to interaction |", err)
+	}
+}
+
+// describePingRule returns 'rule' as text for /config replies
+func describePingRule(rule config.PingRule) string {
+	var conditions []string
+	if rule.FundName != "" {
+		conditions = append(conditions, "fund "+rule.FundName)
+	}
+	if rule.FundTier != 0 {
+		conditions = append(conditions, fmt.Sprintf("tier %d", rule.FundTier))
+	}
+	if rule.Stage != "" {
+		conditions = append(conditions, "stage "+rule.Stage)
+	}
+	return fmt.Sprintf("%s for %s", config.RoleMention(rule.RoleID), strings.Join(conditions, ", "))
+}
+
+// describeSchedule returns when the daily job runs for the config.Schedule
+// 'schedule'
+func describeSchedule(schedule string) string {
+	if schedule == "" {
+		return "every 24 hours from startup"
+	}
+	return fmt.Sprintf("daily at %s UTC", schedule)
+}
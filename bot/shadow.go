@@ -0,0 +1,312 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// discordSession is every discord request the bot makes after starting up.
+// *discordgo.Session makes them for real, shadowSession redirects the ones
+// that change something in shadow mode
+type discordSession interface {
+	ChannelMessage(channelID, messageID string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageSend(channelID string, content string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageSendEmbed(channelID string, embed *discordgo.MessageEmbed, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageSendEmbeds(channelID string, embeds []*discordgo.MessageEmbed, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageSendReply(channelID string, content string, reference *discordgo.MessageReference, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageDelete(channelID, messageID string, options ...discordgo.RequestOption) error
+	MessageReactionAdd(channelID, messageID, emojiID string, options ...discordgo.RequestOption) error
+	MessageReactionRemove(channelID, messageID, emojiID, userID string, options ...discordgo.RequestOption) error
+	MessageReactionsRemoveAll(channelID, messageID string, options ...discordgo.RequestOption) error
+	MessageThreadStart(channelID, messageID string, name string, archiveDuration int, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	ForumThreadStartComplex(channelID string, threadData *discordgo.ThreadStart, messageData *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	Channel(channelID string, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	ChannelEdit(channelID string, data *discordgo.ChannelEdit, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error
+	InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+}
+
+// shadowSession is the discordSession used in shadow mode. Messages are sent
+// to the shadow channel with mentions disabled and a note of the channel they
+// were meant for, reactions and deletes are made there too. Threads and
+// channel edits are only logged. In log mode nothing reaches discord, every
+// change is written to the log file and sent messages get made up IDs so the
+// bot carries on as if they were posted. Reads and interaction responses,
+// which only the operator using the command sees, still go to discord but
+// without mentions. Without a discordSession, as in the replay mode, reads fail
+// with errOffline
+type shadowSession struct {
+	discordSession
+	// channelID is the shadow channel, empty in log mode
+	channelID string
+	mu        sync.Mutex
+	log       *os.File
+	// origins maps the messages posted to the shadow channel to the channel
+	// they were meant for, so reactions to them are handled as if they were
+	// made there
+	origins map[string]string
+	// messages are the messages made up in log mode by ID
+	messages map[string]*discordgo.Message
+	lastID   int64
+	// now is the time for log lines and made up IDs
+	now func() time.Time
+}
+
+// newShadowSession wraps 'session' for config.ShadowMode
+func newShadowSession(session discordSession) (*shadowSession, error) {
+	shadow := &shadowSession{
+		discordSession: session,
+		origins:        map[string]string{},
+		messages:       map[string]*discordgo.Message{},
+		now:            time.Now,
+	}
+	if conf().ShadowMode == "channel" {
+		shadow.channelID = conf().ShadowChannelID
+		return shadow, nil
+	}
+	fileName := conf().ShadowLogFile
+	if !filepath.IsAbs(fileName) {
+		fileName = filepath.Join(conf().DataDir, fileName)
+	}
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("opening shadow log %s | %w", fileName, err)
+	}
+	shadow.log = file
+	return shadow, nil
+}
+
+// record writes an action the bot would have taken to the log file as a json
+// line. It does nothing in channel mode
+func (sh *shadowSession) record(action, channelID, messageID string, detail any) {
+	if sh.log == nil {
+		return
+	}
+	line, err := json.Marshal(map[string]any{
+		"time":      sh.now().UTC(),
+		"action":    action,
+		"channelID": channelID,
+		"messageID": messageID,
+		"detail":    detail,
+	})
+	if err != nil {
+		log.Println("shadow log |", err)
+		return
+	}
+	_, err = sh.log.Write(append(line, '\n'))
+	if err != nil {
+		log.Println("shadow log |", err)
+	}
+}
+
+// discordEpoch is the first millisecond of 2015, the start of discord's
+// snowflake timestamps
+const discordEpoch = 1420070400000
+
+// fakeID returns a made up snowflake for log mode, increasing so later
+// messages sort after earlier ones
+func (sh *shadowSession) fakeID() string {
+	id := (sh.now().UnixMilli() - discordEpoch) << 22
+	if id <= sh.lastID {
+		id = sh.lastID + 1
+	}
+	sh.lastID = id
+	return strconv.FormatInt(id, 10)
+}
+
+// origin returns the channel the shadow message 'messageID' was meant for
+func (sh *shadowSession) origin(messageID string) (string, bool) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	channelID, ok := sh.origins[messageID]
+	return channelID, ok
+}
+
+// send sends 'msg' meant for 'channelID' to the shadow channel or the log
+func (sh *shadowSession) send(channelID string, msg *discordgo.MessageSend) (*discordgo.Message, error) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.channelID == "" {
+		sent := &discordgo.Message{ID: sh.fakeID(), ChannelID: channelID, Content: msg.Content, Embeds: msg.Embeds}
+		sh.messages[sent.ID] = sent
+		sh.record("send", channelID, sent.ID, msg)
+		return sent, nil
+	}
+
+	shadowMsg := *msg
+	shadowMsg.Content = fmt.Sprintf("`shadow of <#%s>`\n%s", channelID, msg.Content)
+	shadowMsg.AllowedMentions = &discordgo.MessageAllowedMentions{}
+	if msg.Reference != nil {
+		failIfNotExists := false
+		shadowMsg.Reference = &discordgo.MessageReference{MessageID: msg.Reference.MessageID, ChannelID: sh.channelID, FailIfNotExists: &failIfNotExists}
+	}
+	sent, err := sh.discordSession.ChannelMessageSendComplex(sh.channelID, &shadowMsg)
+	if err != nil {
+		return nil, err
+	}
+	sh.origins[sent.ID] = channelID
+	// the rest of the bot keeps track of the message where it was meant to go
+	sent.ChannelID = channelID
+	return sent, nil
+}
+
+// change makes the reaction or delete 'action' on the message 'messageID' in
+// the shadow channel with 'fn', or only logs it in log mode
+func (sh *shadowSession) change(action, channelID, messageID string, detail any, fn func(channelID string) error) error {
+	if sh.channelID == "" {
+		sh.mu.Lock()
+		defer sh.mu.Unlock()
+		sh.record(action, channelID, messageID, detail)
+		return nil
+	}
+	return fn(sh.channelID)
+}
+
+func (sh *shadowSession) ChannelMessage(channelID, messageID string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	sh.mu.Lock()
+	fake, ok := sh.messages[messageID]
+	sh.mu.Unlock()
+	if ok {
+		return fake, nil
+	}
+	if sh.discordSession == nil {
+		return nil, errOffline
+	}
+	if origin, ok := sh.origin(messageID); ok {
+		msg, err := sh.discordSession.ChannelMessage(sh.channelID, messageID, options...)
+		if err == nil {
+			msg.ChannelID = origin
+		}
+		return msg, err
+	}
+	return sh.discordSession.ChannelMessage(channelID, messageID, options...)
+}
+
+func (sh *shadowSession) ChannelMessageSend(channelID string, content string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return sh.send(channelID, &discordgo.MessageSend{Content: content})
+}
+
+func (sh *shadowSession) ChannelMessageSendEmbed(channelID string, embed *discordgo.MessageEmbed, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return sh.send(channelID, &discordgo.MessageSend{Embeds: []*discordgo.MessageEmbed{embed}})
+}
+
+func (sh *shadowSession) ChannelMessageSendEmbeds(channelID string, embeds []*discordgo.MessageEmbed, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return sh.send(channelID, &discordgo.MessageSend{Embeds: embeds})
+}
+
+func (sh *shadowSession) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return sh.send(channelID, data)
+}
+
+func (sh *shadowSession) ChannelMessageSendReply(channelID string, content string, reference *discordgo.MessageReference, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return sh.send(channelID, &discordgo.MessageSend{Content: content, Reference: reference})
+}
+
+func (sh *shadowSession) ChannelMessageDelete(channelID, messageID string, options ...discordgo.RequestOption) error {
+	return sh.change("delete", channelID, messageID, nil, func(shadowChannelID string) error {
+		return sh.discordSession.ChannelMessageDelete(shadowChannelID, messageID, options...)
+	})
+}
+
+func (sh *shadowSession) MessageReactionAdd(channelID, messageID, emojiID string, options ...discordgo.RequestOption) error {
+	return sh.change("react", channelID, messageID, emojiID, func(shadowChannelID string) error {
+		return sh.discordSession.MessageReactionAdd(shadowChannelID, messageID, emojiID, options...)
+	})
+}
+
+func (sh *shadowSession) MessageReactionRemove(channelID, messageID, emojiID, userID string, options ...discordgo.RequestOption) error {
+	return sh.change("remove reaction", channelID, messageID, map[string]string{"emoji": emojiID, "userID": userID}, func(shadowChannelID string) error {
+		return sh.discordSession.MessageReactionRemove(shadowChannelID, messageID, emojiID, userID, options...)
+	})
+}
+
+func (sh *shadowSession) MessageReactionsRemoveAll(channelID, messageID string, options ...discordgo.RequestOption) error {
+	return sh.change("remove reactions", channelID, messageID, nil, func(shadowChannelID string) error {
+		return sh.discordSession.MessageReactionsRemoveAll(shadowChannelID, messageID, options...)
+	})
+}
+
+// MessageThreadStart makes up the thread, whatever is sent to it ends up in
+// the shadow channel or the log with the rest
+func (sh *shadowSession) MessageThreadStart(channelID, messageID string, name string, archiveDuration int, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	thread := &discordgo.Channel{ID: sh.fakeID(), ParentID: channelID, Name: name, Type: discordgo.ChannelTypeGuildPublicThread}
+	sh.record("start thread", channelID, messageID, thread)
+	return thread, nil
+}
+
+// ForumThreadStartComplex sends the starter message and returns a thread
+// sharing its ID, as discord does for forum posts
+func (sh *shadowSession) ForumThreadStartComplex(channelID string, threadData *discordgo.ThreadStart, messageData *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	starter := *messageData
+	starter.Content = fmt.Sprintf("forum post **%s**\n%s", threadData.Name, messageData.Content)
+	msg, err := sh.send(channelID, &starter)
+	if err != nil {
+		return nil, err
+	}
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.channelID != "" {
+		sh.origins[msg.ID] = msg.ID
+	}
+	return &discordgo.Channel{ID: msg.ID, ParentID: channelID, Name: threadData.Name, Type: discordgo.ChannelTypeGuildPublicThread}, nil
+}
+
+// ChannelEdit is only logged, the channel is returned unchanged
+func (sh *shadowSession) ChannelEdit(channelID string, data *discordgo.ChannelEdit, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	sh.mu.Lock()
+	sh.record("edit channel", channelID, "", data)
+	sh.mu.Unlock()
+	log.Printf("shadow mode, not editing channel %s\n", channelID)
+	return sh.Channel(channelID, options...)
+}
+
+func (sh *shadowSession) Channel(channelID string, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	if sh.discordSession == nil {
+		return nil, errOffline
+	}
+	return sh.discordSession.Channel(channelID, options...)
+}
+
+func (sh *shadowSession) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	if resp.Data != nil {
+		data := *resp.Data
+		data.AllowedMentions = &discordgo.MessageAllowedMentions{}
+		resp = &discordgo.InteractionResponse{Type: resp.Type, Data: &data}
+	}
+	return sh.discordSession.InteractionRespond(interaction, resp, options...)
+}
+
+func (sh *shadowSession) InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	edit := *newresp
+	edit.AllowedMentions = &discordgo.MessageAllowedMentions{}
+	return sh.discordSession.InteractionResponseEdit(interaction, &edit, options...)
+}
+
+// shadowReaction returns 'm' with the channel set to the one the message was
+// meant for when it's a reaction to a message in the shadow channel
+func shadowReaction(m *discordgo.MessageReactionAdd) *discordgo.MessageReactionAdd {
+	sh, ok := s.(*shadowSession)
+	if !ok || m.ChannelID != sh.channelID {
+		return m
+	}
+	origin, ok := sh.origin(m.MessageID)
+	if !ok {
+		return m
+	}
+	reaction := *m
+	reaction.MessageReaction = &discordgo.MessageReaction{}
+	*reaction.MessageReaction = *m.MessageReaction
+	reaction.ChannelID = origin
+	return &reaction
+}
@@ -0,0 +1,226 @@
+package bot
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+)
+
+// embedColor is the color of the bot's embeds that aren't funding rounds
+const embedColor = 16753920
+
+// defaultRoundColor is the funding round embed color when no color rule matches
+const defaultRoundColor = embedColor
+
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+var (
+	roundTemplate  *template.Template
+	recapTemplate  *template.Template
+	searchTemplate *template.Template
+)
+
+// roundView is the data passed to the round template and to each round of the
+// recap template
+type roundView struct {
+	data.RespData
+	Score float64
+}
+
+// recapView is the data passed to the recap template. Date is the start of
+// the queried range and End its end
+type recapView struct {
+	Date   string
+	End    string
+	Rounds []roundView
+}
+
+// searchView is the data passed to the search result template
+type searchView struct {
+	Index        int
+	Title        string
+	URL          string
+	Snippet      string
+	ProtocolName string
+}
+
+// templateFuncs are the helper functions available in every embed template
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"raise": raiseToString,
+	"symbol": func(r roundView) string {
+		sym, _ := r.Symbol.(string)
+		return sym
+	},
+	"fundsByTier": func(r roundView, tier int) []string {
+		var names []string
+		for _, fund := range r.Funds {
+			if fund.Tier == tier {
+				names = append(names, fund.Name)
+			}
+		}
+		return names
+	},
+	"fundNames": func(r roundView) []string {
+		names := make([]string, len(r.Funds))
+		for i, fund := range r.Funds {
+			names[i] = fund.Name
+		}
+		return names
+	},
+	"join": func(elems []string, sep string) string {
+		return strings.Join(elems, sep)
+	},
+	"joinNonEmpty": func(sep string, elems ...string) string {
+		var nonEmpty []string
+		for _, elem := range elems {
+			if elem != "" {
+				nonEmpty = append(nonEmpty, elem)
+			}
+		}
+		return strings.Join(nonEmpty, sep)
+	},
+	"orNA":     orNA,
+	"contains": stringContainsCaseIns,
+	"lower":    strings.ToLower,
+	"upper":    strings.ToUpper,
+	"date": func(t time.Time, layout string) string {
+		return t.Format(layout)
+	},
+	"color": roundColor,
+}
+
+// roundColor returns the color of the first rule in config.ColorRules matching
+// the round, or defaultRoundColor if none match
+func roundColor(r roundView) int {
+	for _, rule := range conf().ColorRules {
+		if rule.Stage != "" && !stringContainsCaseIns(r.Stage, rule.Stage) {
+			continue
+		}
+		if rule.Category != "" && !stringContainsCaseIns(r.Category.Name, rule.Category) {
+			continue
+		}
+		if rule.MinScore != 0 && r.Score < rule.MinScore {
+			continue
+		}
+		return rule.Color
+	}
+	return defaultRoundColor
+}
+
+// loadTemplates parses the round, recap and search embed templates. A template
+// file in the directory 'dir' replaces the built-in default of the same name.
+// Each template is rendered against sample data so broken templates are caught
+// at startup or reload rather than when the daily rounds are posted
+func loadTemplates(dir string) error {
+	round, err := parseTemplate(dir, "round.tmpl")
+	if err != nil {
+		return err
+	}
+	recap, err := parseTemplate(dir, "recap.tmpl")
+	if err != nil {
+		return err
+	}
+	search, err := parseTemplate(dir, "search.tmpl")
+	if err != nil {
+		return err
+	}
+
+	sample := sampleRoundView()
+	if _, err = renderEmbed(round, sample); err != nil {
+		return err
+	}
+	if _, err = renderEmbed(recap, recapView{Date: "2006-01-01", End: "2006-01-02", Rounds: []roundView{sample, sample}}); err != nil {
+		return err
+	}
+	if _, err = renderEmbed(recap, recapView{Date: "2006-01-02", End: "2006-01-02"}); err != nil {
+		return err
+	}
+	if _, err = renderEmbed(search, searchView{Index: 1, Title: "Title", URL: "https://x.com/example", Snippet: "Snippet", ProtocolName: sample.Name}); err != nil {
+		return err
+	}
+	// only swap in templates once all of them render
+	roundTemplate, recapTemplate, searchTemplate = round, recap, search
+	return nil
+}
+
+// parseTemplate parses the template file 'name' from the directory 'dir' if
+// it exists there, otherwise from the built-in defaults
+func parseTemplate(dir, name string) (*template.Template, error) {
+	var text []byte
+	var err error
+	if dir != "" {
+		text, err = os.ReadFile(filepath.Join(dir, name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("reading template %s | %w", name, err)
+		}
+	}
+	if text == nil {
+		text, err = defaultTemplates.ReadFile("templates/" + name)
+		if err != nil {
+			return nil, fmt.Errorf("reading default template %s | %w", name, err)
+		}
+	}
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("parsing template %s | %w", name, err)
+	}
+	return t, nil
+}
+
+// renderEmbed executes the template 't' with 'view' and unmarshals the output
+// into a discord embed
+func renderEmbed(t *template.Template, view any) (*discordgo.MessageEmbed, error) {
+	var buf bytes.Buffer
+	err := t.Execute(&buf, view)
+	if err != nil {
+		return nil, fmt.Errorf("executing template %s | %w", t.Name(), err)
+	}
+	embed := &discordgo.MessageEmbed{}
+	err = json.Unmarshal(buf.Bytes(), embed)
+	if err != nil {
+		return nil, fmt.Errorf("template %s did not render a valid embed | %w", t.Name(), data.JsonMarshalError{OriginalErr: err})
+	}
+	return embed, nil
+}
+
+// sampleRoundView returns a funding round with every commonly used field set,
+// used to validate templates on startup
+func sampleRoundView() roundView {
+	var r roundView
+	r.Name = "Example Protocol"
+	r.Key = "example-protocol"
+	r.Symbol = "EXP"
+	r.Icon = "https://example.com/icon.png"
+	r.Date = time.Date(2006, 1, 2, 0, 0, 0, 0, time.UTC)
+	r.Raise = 5000000
+	r.TotalRaise = 12000000
+	r.Stage = "Seed"
+	r.Category.Name = "Infrastructure"
+	r.Funds = []data.RespFund{{Name: "Tier One Capital", Key: "tier-one", Tier: 1}, {Name: "Tier Two Ventures", Key: "tier-two", Tier: 2}}
+	r.Score = 42
+	return r
+}
+
+// orNA returns "N/A" for an empty string, discord rejects empty embed fields
+func orNA(s string) string {
+	if s == "" {
+		return "N/A"
+	}
+	return s
+}
@@ -0,0 +1,239 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+)
+
+const (
+	// threadAutoArchiveMinutes is how long a round thread stays active without
+	// any new messages before discord archives it
+	threadAutoArchiveMinutes = 1440
+	// maxForumTags is the most tags discord allows on a forum channel
+	maxForumTags = 20
+	// maxForumTagLength is the longest name discord allows for a forum tag
+	maxForumTagLength = 20
+	// maxThreadNameLength is the longest name discord allows for a thread
+	maxThreadNameLength = 100
+)
+
+// roundPost is where a funding round embed was posted. Follow up messages for
+// the round go to the thread if there is one, otherwise they reply to the embed
+type roundPost struct {
+	ChannelID string
+	MessageID string
+	ThreadID  string
+}
+
+// followUpChannel returns the channel id follow up messages for the round
+// should be sent to
+func (p roundPost) followUpChannel() string {
+	if p.ThreadID != "" {
+		return p.ThreadID
+	}
+	return p.ChannelID
+}
+
+// replyRef returns a reference to the round embed if follow ups are sent to
+// the same channel as the embed, otherwise nil since discord can't reply to
+// a message in another channel
+func (p roundPost) replyRef() *discordgo.MessageReference {
+	if p.followUpChannel() != p.ChannelID {
+		return nil
+	}
+	return &discordgo.MessageReference{
+		MessageID: p.MessageID,
+		ChannelID: p.ChannelID,
+		GuildID:   conf().GuildID,
+	}
+}
+
+// postRound sends a funding round embed according to config.RoundThreads.
+// Rounds for a protocol that already has a thread are posted in that thread.
+// Otherwise "thread" starts a thread on the embed in the default channel,
+// "forum" creates a forum post tagged with the round's stage and category and
+// anything else posts the embed in the default channel without a thread. The
+// protocol's ThreadID is set when a new thread is created. A 'channelID' other
+// than empty posts the embed there without any thread
+func postRound(s discordSession, entry data.RespData, embed *discordgo.MessageEmbed, channelID string) (roundPost, error) {
+	if channelID != "" {
+		msg, err := s.ChannelMessageSendEmbed(channelID, embed)
+		if err != nil {
+			return roundPost{}, fmt.Errorf("sending embed to channel %s | %w", channelID, discordSendError(err))
+		}
+		return roundPost{ChannelID: channelID, MessageID: msg.ID}, nil
+	}
+	if threadID := protocols.M[entry.Name].ThreadID; threadID != "" {
+		msg, err := s.ChannelMessageSendEmbed(threadID, embed)
+		if err == nil {
+			return roundPost{ChannelID: threadID, MessageID: msg.ID, ThreadID: threadID}, nil
+		}
+		// only a thread discord refused to post in, like a deleted one, gets
+		// a new round. Any other failure may have posted the embed anyway
+		var restErr *discordgo.RESTError
+		if !errors.As(err, &restErr) || restErr.Response == nil || restErr.Response.StatusCode < 400 ||
+			restErr.Response.StatusCode >= 500 || restErr.Response.StatusCode == http.StatusTooManyRequests {
+			return roundPost{}, fmt.Errorf("sending embed to thread %s | %w", threadID, discordSendError(err))
+		}
+		log.Printf("posting round for %s to its thread %s, starting a new one | %v\n", entry.Name, threadID, err)
+	}
+
+	var post roundPost
+	switch conf().RoundThreads {
+	case "forum":
+		thread, err := s.ForumThreadStartComplex(conf().ForumChannelID, &discordgo.ThreadStart{
+			Name:                threadName(entry.Name),
+			AutoArchiveDuration: threadAutoArchiveMinutes,
+			AppliedTags:         forumTagIDs(s, entry.Stage, entry.Category.Name),
+		}, &discordgo.MessageSend{Embeds: []*discordgo.MessageEmbed{embed}})
+		if err != nil {
+			return roundPost{}, fmt.Errorf("starting forum post in channel %s | %w", conf().ForumChannelID, discordSendError(err))
+		}
+		// the starter message of a forum post shares its id with the thread
+		post = roundPost{ChannelID: thread.ID, MessageID: thread.ID, ThreadID: thread.ID}
+
+	case "thread":
+		msg, err := s.ChannelMessageSendEmbed(conf().DefaultChannelID, embed)
+		if err != nil {
+			return roundPost{}, fmt.Errorf("sending embed to channel %s | %w", conf().DefaultChannelID, discordSendError(err))
+		}
+		post = roundPost{ChannelID: conf().DefaultChannelID, MessageID: msg.ID}
+		thread, err := s.MessageThreadStart(conf().DefaultChannelID, msg.ID, threadName(entry.Name), threadAutoArchiveMinutes)
+		if err != nil {
+			// the embed is already out, carry on without a thread
+			log.Printf("starting thread on message %s | %v\n", msg.ID, err)
+			return post, nil
+		}
+		post.ThreadID = thread.ID
+
+	default:
+		msg, err := s.ChannelMessageSendEmbed(conf().DefaultChannelID, embed)
+		if err != nil {
+			return roundPost{}, fmt.Errorf("sending embed to channel %s | %w", conf().DefaultChannelID, discordSendError(err))
+		}
+		return roundPost{ChannelID: conf().DefaultChannelID, MessageID: msg.ID}, nil
+	}
+
+	// store the thread so later follow ups for the protocol go into it
+	protocol, ok := protocols.M[entry.Name]
+	if !ok {
+		protocol = data.Protocol{Name: entry.Name, New: true}
+		protocols.Appended = true
+	} else if !protocol.New {
+		protocols.Modified = true
+	}
+	protocol.ThreadID = post.ThreadID
+	protocols.M[entry.Name] = protocol
+	return post, nil
+}
+
+// sendFollowUp sends 'msg' as a follow up to a posted funding round
+func sendFollowUp(s discordSession, post roundPost, msg *discordgo.MessageSend) (*discordgo.Message, error) {
+	msg.Reference = post.replyRef()
+	return s.ChannelMessageSendComplex(post.followUpChannel(), msg)
+}
+
+// protocolChannel returns the thread id of the protocol named 'name' if it has
+// one, otherwise 'fallback'
+func protocolChannel(name, fallback string) string {
+	if threadID := protocols.M[name].ThreadID; threadID != "" {
+		return threadID
+	}
+	return fallback
+}
+
+// messageChannel returns the channel id an unprocessed message was sent to.
+// Messages saved before threads were supported were all sent to the default
+// channel
+func messageChannel(msg data.UnprocessedMessage) string {
+	if msg.ChannelID != "" {
+		return msg.ChannelID
+	}
+	return conf().DefaultChannelID
+}
+
+// parentChannel returns the channel id of the parent message of an
+// unprocessed message
+func parentChannel(msg data.UnprocessedMessage) string {
+	if msg.ParentChannelID != "" {
+		return msg.ParentChannelID
+	}
+	return conf().DefaultChannelID
+}
+
+// forumTagIDs returns the ids of the forum channel's tags matching 'names', case
+// insensitive. Missing tags are created while the channel has room for them.
+// Errors are logged and the tags found so far are returned, a post without
+// tags is better than no post
+func forumTagIDs(s discordSession, names ...string) []string {
+	forum, err := s.Channel(conf().ForumChannelID)
+	if err != nil {
+		log.Printf("getting forum channel %s | %v\n", conf().ForumChannelID, err)
+		return nil
+	}
+	tags := forum.AvailableTags
+	var ids []string
+	var missing []string
+	for _, name := range names {
+		name = truncate(strings.TrimSpace(name), maxForumTagLength)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, tag := range tags {
+			if strings.EqualFold(tag.Name, name) {
+				ids = append(ids, tag.ID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return ids
+	}
+
+	for _, name := range missing {
+		if len(tags) >= maxForumTags {
+			log.Printf("forum channel %s has no room for tag %s\n", conf().ForumChannelID, name)
+			break
+		}
+		tags = append(tags, discordgo.ForumTag{Name: name})
+	}
+	edited, err := s.ChannelEdit(conf().ForumChannelID, &discordgo.ChannelEdit{AvailableTags: &tags})
+	if err != nil {
+		log.Printf("adding tags to forum channel %s | %v\n", conf().ForumChannelID, err)
+		return ids
+	}
+	for _, name := range missing {
+		for _, tag := range edited.AvailableTags {
+			if strings.EqualFold(tag.Name, name) {
+				ids = append(ids, tag.ID)
+				break
+			}
+		}
+	}
+	return ids
+}
+
+// threadName returns a protocol name shortened to fit a thread name
+func threadName(name string) string {
+	return truncate(name, maxThreadNameLength)
+}
+
+// truncate shortens 's' to at most 'n' runes
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
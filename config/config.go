@@ -3,70 +3,237 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
 	"os"
 )
 
-var (
-	Token               string
-	BotPrefix           string
-	DefaultChannelID    string
-	GuildID             string
-	FundingRoundRoleID  string
-	EarlyRoundRoleID    string
-	BinanceRoundRoleID  string
-	ParadigmRoundRoleID string
-	CoinbaseRoundRoleID string
-	BotOperatorRoleID   string
-	TwitterEmoji        string
-	TwitterEmojiName    string
+type Config struct {
+	Token               string        `json:"token"`
+	BotPrefix           string        `json:"botPrefix"`
+	DefaultChannelID    string        `json:"channelID"`
+	GuildID             string        `json:"guildID"`
+	FundingRoundRoleID  string        `json:"fundingRoundRoleID"`
+	EarlyRoundRoleID    string        `json:"earlyRoundRoleID"`
+	BinanceRoundRoleID  string        `json:"binanceRoundRoleID"`
+	ParadigmRoundRoleID string        `json:"paradigmRoundRoleID"`
+	CoinbaseRoundRoleID string        `json:"coinbaseRoundRoleID"`
+	BotOperatorRoleID   string        `json:"botOperatorRoleID"`
+	TwitterEmojiName    string        `json:"twitterEmojiName"`
+	TwitterEmojiID      string        `json:"twitterEmojiID"`
+	Scoring             ScoringConfig `json:"scoring"`
+	TemplatesDir        string        `json:"templatesDir"`
+	ColorRules          []ColorRule   `json:"colorRules"`
+	// RoundThreads is "thread" to start a thread on each round embed, "forum"
+	// to create a post per round in ForumChannelID, or empty for no threads
+	RoundThreads   string `json:"roundThreads"`
+	ForumChannelID string `json:"forumChannelID"`
+	// PingRules decide which roles get tagged for each funding round. When
+	// left out they are built from the binance, coinbase, paradigm and early
+	// round role IDs
+	PingRules []PingRule `json:"pingRules"`
+	// AlertChannelID is where operators are alerted when the daily job fails,
+	// leave empty to only log failures
+	AlertChannelID string `json:"alertChannelID"`
+	// HandleConfidence is the confidence from 0 to 1 a twitter handle found
+	// by the resolvers needs to be stored without asking an operator
+	HandleConfidence float64 `json:"handleConfidence"`
+	// CuratedHandlesFile is a json file mapping protocol names or cryptorank
+	// keys to twitter urls, checked before searching
+	CuratedHandlesFile string `json:"curatedHandlesFile"`
+	// GoogleDailyQuota is the number of custom searches allowed per day, 100 on
+	// Google's free tier
+	GoogleDailyQuota int `json:"googleDailyQuota"`
+	// SearchCacheHours is how long Google search results are reused for
+	SearchCacheHours int `json:"searchCacheHours"`
+	// StrictLoad refuses to start when a store file has malformed lines or
+	// leftover backup files instead of recovering what it can
+	StrictLoad bool `json:"strictLoad"`
+	// Storage is "bolt" to keep data in the embedded database at DatabaseFile
+	// or "jsonl" for the .jsonl files
+	Storage      string `json:"storage"`
+	DatabaseFile string `json:"databaseFile"`
+	// DataDir is the directory the .jsonl stores and the database are kept
+	// in, a relative DatabaseFile is inside it
+	DataDir string `json:"dataDir"`
+	// Schedule is the time of day the daily job runs as HH:MM in UTC, when
+	// empty it runs every 24 hours from startup
+	Schedule string `json:"schedule"`
+	// Permissions grant each capability by name to roles and users in every
+	// guild, see Grant for the capabilities left out
+	Permissions map[string]Grant `json:"permissions"`
+	// GuildPermissions grant capabilities by guild ID, a capability granted
+	// for a guild replaces its grant in Permissions there
+	GuildPermissions map[string]map[string]Grant `json:"guildPermissions"`
+	// ShadowMode is "channel" to send everything the bot would post, react,
+	// edit or delete to ShadowChannelID with mentions disabled, "log" to only
+	// write it to ShadowLogFile, or empty to run normally. Nothing is stored in
+	// shadow mode, state changes only last until the bot stops
+	ShadowMode      string `json:"shadowMode"`
+	ShadowChannelID string `json:"shadowChannelID"`
+	// ShadowLogFile is relative to DataDir unless absolute
+	ShadowLogFile string `json:"shadowLogFile"`
+	// ArchiveDir keeps every cryptorank funding rounds response in a
+	// directory per day for the replay mode, relative to DataDir unless
+	// absolute. Empty turns archiving off
+	ArchiveDir string `json:"archiveDir"`
+}
 
-	config *Config
-)
+// PingRule tags the role RoleID when a funding round matches. A rule matches
+// when every condition it sets matches; FundName is a case insensitive
+// substring of the name of a fund in the round, FundTier limits the funds
+// checked to that tier (0 for any tier) and Stage is a case insensitive
+// substring of the round's stage
+type PingRule struct {
+	RoleID   string `json:"roleID"`
+	FundName string `json:"fundName"`
+	FundTier int    `json:"fundTier"`
+	Stage    string `json:"stage"`
+}
 
-type Config struct {
-	Token               string `json:"token"`
-	BotPrefix           string `json:"botPrefix"`
-	DefaultChannelID    string `json:"channelID"`
-	GuildID             string `json:"guildID"`
-	FundingRoundRoleID  string `json:"fundingRoundRoleID"`
-	EarlyRoundRoleID    string `json:"earlyRoundRoleID"`
-	BinanceRoundRoleID  string `json:"binanceRoundRoleID"`
-	ParadigmRoundRoleID string `json:"paradigmRoundRoleID"`
-	CoinbaseRoundRoleID string `json:"coinbaseRoundRoleID"`
-	BotOperatorRoleID   string `json:"botOperatorRoleID"`
-	TwitterEmojiName    string `json:"twitterEmojiName"`
-	TwitterEmojiID      string `json:"twitterEmojiID"`
+// legacyPingRules returns the ping rules matching the bot's behaviour before
+// ping rules were configurable. Roles without an ID are skipped
+func legacyPingRules(c *Config) []PingRule {
+	candidates := []PingRule{
+		{RoleID: c.BinanceRoundRoleID, FundName: "Binance", FundTier: 1},
+		{RoleID: c.CoinbaseRoundRoleID, FundName: "Coinbase", FundTier: 1},
+		{RoleID: c.ParadigmRoundRoleID, FundName: "Paradigm", FundTier: 1},
+		{RoleID: c.EarlyRoundRoleID, Stage: "Seed"},
+	}
+	var rules []PingRule
+	for _, rule := range candidates {
+		if rule.RoleID != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
 }
 
-// ReadConfig reads the config.json file and unmarshals it into the Config struct
-func ReadConfig() error {
-	// read config file in entirety
-	fmt.Println("Reading config.json...")
-	file, err := os.ReadFile("./config.json")
-	if err != nil {
-		return err
+// ColorRule sets the color of a funding round embed. A rule matches when every
+// condition it sets matches; Stage and Category are case insensitive substrings
+// and MinScore is ignored when 0. The first matching rule wins
+type ColorRule struct {
+	Stage    string  `json:"stage"`
+	Category string  `json:"category"`
+	MinScore float64 `json:"minScore"`
+	Color    int     `json:"color"`
+}
+
+// ScoringConfig holds the weights used to score funding rounds by airdrop
+// potential. Raise, total raise and follower counts are scored on a log10 scale
+// so a single huge round doesn't drown out everything else. Any weight left out
+// of config.json keeps its default from DefaultScoring
+type ScoringConfig struct {
+	// RaiseWeight is the points per order of magnitude of the round's raise in $M
+	RaiseWeight float64 `json:"raiseWeight"`
+	// TotalRaiseWeight is the points per order of magnitude of the protocol's total raise in $M
+	TotalRaiseWeight float64 `json:"totalRaiseWeight"`
+	// Tier1FundWeight is the points per tier 1 fund in the round
+	Tier1FundWeight float64 `json:"tier1FundWeight"`
+	// Tier2FundWeight is the points per tier 2 fund in the round
+	Tier2FundWeight float64 `json:"tier2FundWeight"`
+	// FundCountWeight is the points per fund of any tier in the round
+	FundCountWeight float64 `json:"fundCountWeight"`
+	// TopFunds maps a fund name (case insensitive substring) to bonus points
+	// added when that fund takes part in the round
+	TopFunds map[string]float64 `json:"topFunds"`
+	// TwitterScoreWeight is the points per point of the protocol's twitter score
+	TwitterScoreWeight float64 `json:"twitterScoreWeight"`
+	// FollowersWeight is the points per order of magnitude of twitter followers
+	FollowersWeight float64 `json:"followersWeight"`
+	// Categories maps a category name (case insensitive) to bonus points,
+	// negative values can be used to bury categories
+	Categories map[string]float64 `json:"categories"`
+	// NoTokenBonus is added when the protocol doesn't have a token yet
+	NoTokenBonus float64 `json:"noTokenBonus"`
+	// MinIndividualScore is the score a round needs to get its own embed
+	// posted. Rounds below it are still listed in the recap
+	MinIndividualScore float64 `json:"minIndividualScore"`
+}
+
+// DefaultScoring returns the scoring weights used for anything not set in
+// config.json
+func DefaultScoring() ScoringConfig {
+	return ScoringConfig{
+		RaiseWeight:        10,
+		TotalRaiseWeight:   5,
+		Tier1FundWeight:    8,
+		Tier2FundWeight:    3,
+		FundCountWeight:    1,
+		TwitterScoreWeight: 0.05,
+		FollowersWeight:    2,
+		NoTokenBonus:       10,
+		MinIndividualScore: 0,
 	}
+}
 
-	// unmarshal file into config struct
-	fmt.Println("Unmarshalling config.json...")
-	err = json.Unmarshal(file, &config)
+// defaultTopFunds is used when config.json doesn't set scoring.topFunds
+var defaultTopFunds = map[string]float64{
+	"Binance":   10,
+	"Coinbase":  10,
+	"Paradigm":  10,
+	"a16z":      8,
+	"Polychain": 6,
+}
+
+// Load builds the config in layers and validates it without setting any
+// globals. Defaults come first, then the json file at 'path' if it isn't
+// empty, then AIRDROPBOT_* environment variables and finally 'flags', the
+// values of command line flags set by field name. Syntax errors in the file
+// are reported with their line and column, everything else as a
+// *ValidationError
+func Load(path string, flags map[string]string) (*Config, error) {
+	c := &Config{
+		Scoring:            DefaultScoring(),
+		TemplatesDir:       "templates",
+		HandleConfidence:   0.8,
+		CuratedHandlesFile: "twitter_handles.json",
+		GoogleDailyQuota:   100,
+		SearchCacheHours:   168,
+		Storage:            "jsonl",
+		DatabaseFile:       "airdrop.db",
+		DataDir:            ".",
+		ShadowLogFile:      "shadow.log",
+		ArchiveDir:         "archive",
+	}
+
+	var problems []Problem
+	if path != "" {
+		file, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(file, c)
+		if err != nil {
+			return nil, jsonError(path, file, err)
+		}
+		problems = append(problems, unknownFields(file)...)
+	}
+	problems = append(problems, c.applyEnv()...)
+	problems = append(problems, c.applyFlags(flags)...)
+
+	err := c.Validate(problems)
 	if err != nil {
-		fmt.Println("Error unmarshalling config.json")
-		return err
+		return nil, err
 	}
 
-	Token = config.Token
-	BotPrefix = config.BotPrefix
-	DefaultChannelID = config.DefaultChannelID
-	GuildID = config.GuildID
-	//TODO: change all these two fmt.Sprintf()
-	FundingRoundRoleID = "<@&" + config.FundingRoundRoleID + ">"
-	EarlyRoundRoleID = "<@&" + config.EarlyRoundRoleID + ">"
-	BinanceRoundRoleID = "<@&" + config.BinanceRoundRoleID + ">"
-	ParadigmRoundRoleID = "<@&" + config.ParadigmRoundRoleID + ">"
-	CoinbaseRoundRoleID = "<@&" + config.CoinbaseRoundRoleID + ">"
-	BotOperatorRoleID = config.BotOperatorRoleID
-	TwitterEmoji = fmt.Sprintf("%s:%s", config.TwitterEmojiName, config.TwitterEmojiID)
-	TwitterEmojiName = config.TwitterEmojiName
-	return nil
+	// fill in what can only be defaulted after reading the file
+	if c.Scoring.TopFunds == nil {
+		// copied so changing one config's funds doesn't change the defaults
+		c.Scoring.TopFunds = maps.Clone(defaultTopFunds)
+	}
+	if c.PingRules == nil {
+		c.PingRules = legacyPingRules(c)
+	}
+	return c, nil
+}
+
+// TwitterEmoji returns the twitter emoji in the name:id form discord uses for
+// reactions
+func (c *Config) TwitterEmoji() string {
+	return fmt.Sprintf("%s:%s", c.TwitterEmojiName, c.TwitterEmojiID)
+}
+
+// RoleMention returns the mention of the role with id 'roleID'
+func RoleMention(roleID string) string {
+	return fmt.Sprintf("<@&%s>", roleID)
 }
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// EnvPrefix starts the name of every environment variable read by the config
+const EnvPrefix = "AIRDROPBOT_"
+
+// settableField is a top level Config field with a single value that can be
+// set from the environment or a flag. Lists and nested settings like scoring
+// or pingRules can only be set in the file
+type settableField struct {
+	// Name is the field's json name e.g. botOperatorRoleID
+	Name  string
+	index int
+	kind  reflect.Kind
+}
+
+// EnvName is the environment variable for the field e.g.
+// AIRDROPBOT_BOT_OPERATOR_ROLE_ID. The same name with a _FILE suffix is read
+// from the file it points to, for secrets mounted by docker or kubernetes
+func (f settableField) EnvName() string {
+	return EnvPrefix + strings.ToUpper(splitCamel(f.Name, '_'))
+}
+
+// FlagName is the command line flag for the field e.g. bot-operator-role-id
+func (f settableField) FlagName() string {
+	return strings.ToLower(splitCamel(f.Name, '-'))
+}
+
+// settableFields lists every Config field that can be set from the
+// environment or a flag
+func settableFields() []settableField {
+	t := reflect.TypeOf(Config{})
+	var fields []settableField
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		kind := t.Field(i).Type.Kind()
+		if name == "" || name == "-" {
+			continue
+		}
+		switch kind {
+		case reflect.String, reflect.Bool, reflect.Int, reflect.Float64:
+			fields = append(fields, settableField{Name: name, index: i, kind: kind})
+		}
+	}
+	return fields
+}
+
+// RegisterFlags adds a string flag for every settable config field to 'fs'.
+// The returned function, called after parsing, returns the values of the flags
+// that were set keyed by field name, ready to pass to Load
+func RegisterFlags(fs *flag.FlagSet) func() map[string]string {
+	byFlag := map[string]string{}
+	for _, field := range settableFields() {
+		byFlag[field.FlagName()] = field.Name
+		fs.String(field.FlagName(), "", fmt.Sprintf("overrides %s from the config file and %s", field.Name, field.EnvName()))
+	}
+	return func() map[string]string {
+		values := map[string]string{}
+		fs.Visit(func(f *flag.Flag) {
+			if name, ok := byFlag[f.Name]; ok {
+				values[name] = f.Value.String()
+			}
+		})
+		return values
+	}
+}
+
+// applyEnv sets every field that has an AIRDROPBOT_ environment variable, or a
+// _FILE variable pointing to a file holding the value. A _FILE variable wins
+// over a plain one
+func (c *Config) applyEnv() []Problem {
+	var problems []Problem
+	for _, field := range settableFields() {
+		name := field.EnvName()
+		value, ok := os.LookupEnv(name)
+		if path, fileOK := os.LookupEnv(name + "_FILE"); fileOK {
+			contents, err := os.ReadFile(path)
+			if err != nil {
+				problems = append(problems, Problem{Path: name + "_FILE", Message: fmt.Sprintf("reading %s | %v", path, err)})
+				continue
+			}
+			value, ok = strings.TrimSpace(string(contents)), true
+			name += "_FILE"
+		}
+		if !ok {
+			continue
+		}
+		if err := c.set(field, value); err != nil {
+			problems = append(problems, Problem{Path: name, Message: err.Error()})
+		}
+	}
+	return problems
+}
+
+// applyFlags sets the fields in 'flags', keyed by field name
+func (c *Config) applyFlags(flags map[string]string) []Problem {
+	var problems []Problem
+	for _, field := range settableFields() {
+		value, ok := flags[field.Name]
+		if !ok {
+			continue
+		}
+		if err := c.set(field, value); err != nil {
+			problems = append(problems, Problem{Path: "-" + field.FlagName(), Message: err.Error()})
+		}
+	}
+	return problems
+}
+
+// set parses 'value' into 'field' of the config
+func (c *Config) set(field settableField, value string) error {
+	v := reflect.ValueOf(c).Elem().Field(field.index)
+	switch field.kind {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q must be true or false", value)
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q must be a whole number", value)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q must be a number", value)
+		}
+		v.SetFloat(n)
+	}
+	return nil
+}
+
+// splitCamel splits a camel case name into words joined by 'sep', keeping
+// runs of capitals like ID together e.g. channelID becomes channel_ID
+func splitCamel(name string, sep rune) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && !unicode.IsUpper(runes[i-1]) {
+			b.WriteRune(sep)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
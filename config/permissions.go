@@ -0,0 +1,48 @@
+package config
+
+// Capabilities that can be granted with Config.Permissions
+const (
+	// CapView is using the read only commands like /list-protocols
+	CapView = "view"
+	// CapResolveTwitter is reacting to pick, search for or undo a protocol's
+	// twitter and /undo-twitter
+	CapResolveTwitter = "resolve-twitter"
+	CapEditProtocol   = "edit-protocol"
+	// CapConfigure is /config and /reload-config
+	CapConfigure  = "configure"
+	CapTriggerRun = "trigger-run"
+	CapViewAudit  = "view-audit"
+)
+
+// Capabilities lists every capability that can be granted
+var Capabilities = []string{CapView, CapResolveTwitter, CapEditProtocol, CapConfigure, CapTriggerRun, CapViewAudit}
+
+// Grant gives a capability to members of the guild with any of Roles, to the
+// users Users anywhere and, with Administrator, to the guild's administrators.
+// The guild ID is the ID of its @everyone role so it grants everyone
+type Grant struct {
+	Roles         []string `json:"roles"`
+	Users         []string `json:"users"`
+	Administrator bool     `json:"administrator"`
+}
+
+// Grant returns who has 'capability' in the guild 'guildID'. The guild's own
+// grant from GuildPermissions is used first, then the one from Permissions.
+// Capabilities left out of both are granted to the bot operator role, view to
+// everyone in the guild and configure to administrators as well
+func (c *Config) Grant(guildID, capability string) Grant {
+	if grant, ok := c.GuildPermissions[guildID][capability]; ok {
+		return grant
+	}
+	if grant, ok := c.Permissions[capability]; ok {
+		return grant
+	}
+	switch capability {
+	case CapView:
+		return Grant{Roles: []string{guildID}}
+	case CapConfigure:
+		return Grant{Roles: []string{c.BotOperatorRoleID}, Administrator: true}
+	default:
+		return Grant{Roles: []string{c.BotOperatorRoleID}}
+	}
+}
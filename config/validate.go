@@ -0,0 +1,294 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+var (
+	// snowflakePattern matches a discord id
+	snowflakePattern = regexp.MustCompile(`^[0-9]{17,20}$`)
+	// emojiNamePattern matches the name of a custom discord emoji
+	emojiNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{2,32}$`)
+	// schedulePattern matches a time of day as HH:MM
+	schedulePattern = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+)
+
+// Problem is a single thing wrong with the config at the json path Path, e.g.
+// pingRules[2].roleID
+type Problem struct {
+	Path    string
+	Message string
+}
+
+// ValidationError lists every problem found in the config
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		lines[i] = fmt.Sprintf("  %s: %s", problem.Path, problem.Message)
+	}
+	return "invalid config\n" + strings.Join(lines, "\n")
+}
+
+// validator collects problems while checking the config
+type validator struct {
+	problems []Problem
+}
+
+func (v *validator) add(path, format string, args ...any) {
+	v.problems = append(v.problems, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// required checks 'value' isn't empty
+func (v *validator) required(path, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.add(path, "is required")
+		return false
+	}
+	return true
+}
+
+// snowflake checks an optional discord id, 'what' names it in the message
+func (v *validator) snowflake(path, value, what string) {
+	if value != "" && !snowflakePattern.MatchString(value) {
+		v.add(path, "%q is not a %s id, copy it from discord with developer mode on", value, what)
+	}
+}
+
+// permissions checks the capability names and the role and user ids of the
+// grants in 'permissions'
+func (v *validator) permissions(path string, permissions map[string]Grant) {
+	capabilities := make([]string, 0, len(permissions))
+	for capability := range permissions {
+		capabilities = append(capabilities, capability)
+	}
+	sort.Strings(capabilities)
+	for _, capability := range capabilities {
+		grant := permissions[capability]
+		path := path + "." + capability
+		if !slices.Contains(Capabilities, capability) {
+			v.add(path, "is not a capability, must be one of %s", strings.Join(Capabilities, ", "))
+		}
+		for i, roleID := range grant.Roles {
+			v.snowflake(fmt.Sprintf("%s.roles[%d]", path, i), roleID, "role")
+		}
+		for i, userID := range grant.Users {
+			v.snowflake(fmt.Sprintf("%s.users[%d]", path, i), userID, "user")
+		}
+	}
+}
+
+// Validate checks the config and returns a *ValidationError listing every
+// problem found along with the problems in 'found', already found while
+// reading the config
+func (c *Config) Validate(found []Problem) error {
+	v := &validator{problems: found}
+
+	if v.required("token", c.Token) && c.Token == "insert_bot_token_here" {
+		v.add("token", "is still the placeholder from config.json.example")
+	}
+	if v.required("guildID", c.GuildID) {
+		v.snowflake("guildID", c.GuildID, "server")
+	}
+	if v.required("channelID", c.DefaultChannelID) {
+		v.snowflake("channelID", c.DefaultChannelID, "channel")
+	}
+	if v.required("botOperatorRoleID", c.BotOperatorRoleID) {
+		v.snowflake("botOperatorRoleID", c.BotOperatorRoleID, "role")
+	}
+	if v.required("fundingRoundRoleID", c.FundingRoundRoleID) {
+		v.snowflake("fundingRoundRoleID", c.FundingRoundRoleID, "role")
+	}
+	v.snowflake("earlyRoundRoleID", c.EarlyRoundRoleID, "role")
+	v.snowflake("binanceRoundRoleID", c.BinanceRoundRoleID, "role")
+	v.snowflake("paradigmRoundRoleID", c.ParadigmRoundRoleID, "role")
+	v.snowflake("coinbaseRoundRoleID", c.CoinbaseRoundRoleID, "role")
+
+	if v.required("twitterEmojiName", c.TwitterEmojiName) && !emojiNamePattern.MatchString(c.TwitterEmojiName) {
+		v.add("twitterEmojiName", "%q is not an emoji name, use the name without colons e.g. twitterlogo", c.TwitterEmojiName)
+	}
+	if v.required("twitterEmojiID", c.TwitterEmojiID) {
+		v.snowflake("twitterEmojiID", c.TwitterEmojiID, "emoji")
+	}
+
+	switch c.RoundThreads {
+	case "", "thread":
+	case "forum":
+		if v.required("forumChannelID", c.ForumChannelID) {
+			v.snowflake("forumChannelID", c.ForumChannelID, "channel")
+		}
+	default:
+		v.add("roundThreads", "%q must be \"thread\", \"forum\" or empty", c.RoundThreads)
+	}
+	v.snowflake("alertChannelID", c.AlertChannelID, "channel")
+
+	for i, rule := range c.PingRules {
+		path := fmt.Sprintf("pingRules[%d]", i)
+		if v.required(path+".roleID", rule.RoleID) {
+			v.snowflake(path+".roleID", rule.RoleID, "role")
+		}
+		if rule.FundTier < 0 {
+			v.add(path+".fundTier", "must be 0 for any tier or a positive tier")
+		}
+		if rule.FundName == "" && rule.FundTier == 0 && rule.Stage == "" {
+			v.add(path, "needs at least one of fundName, fundTier or stage, it never matches")
+		}
+	}
+	for i, rule := range c.ColorRules {
+		if rule.Color < 0 || rule.Color > 0xFFFFFF {
+			v.add(fmt.Sprintf("colorRules[%d].color", i), "%d is not a color, must be from 0 to 16777215", rule.Color)
+		}
+	}
+
+	if c.HandleConfidence < 0 || c.HandleConfidence > 1 {
+		v.add("handleConfidence", "%v must be from 0 to 1", c.HandleConfidence)
+	}
+	if c.GoogleDailyQuota < 0 {
+		v.add("googleDailyQuota", "can't be negative")
+	}
+	if c.SearchCacheHours < 0 {
+		v.add("searchCacheHours", "can't be negative")
+	}
+	if c.Schedule != "" && !schedulePattern.MatchString(c.Schedule) {
+		v.add("schedule", "%q must be a time of day as HH:MM in UTC or empty", c.Schedule)
+	}
+	switch c.ShadowMode {
+	case "":
+	case "channel":
+		if v.required("shadowChannelID", c.ShadowChannelID) {
+			v.snowflake("shadowChannelID", c.ShadowChannelID, "channel")
+		}
+	case "log":
+		v.required("shadowLogFile", c.ShadowLogFile)
+	default:
+		v.add("shadowMode", "%q must be \"channel\", \"log\" or empty", c.ShadowMode)
+	}
+	v.permissions("permissions", c.Permissions)
+	guildIDs := make([]string, 0, len(c.GuildPermissions))
+	for guildID := range c.GuildPermissions {
+		guildIDs = append(guildIDs, guildID)
+	}
+	sort.Strings(guildIDs)
+	for _, guildID := range guildIDs {
+		path := "guildPermissions." + guildID
+		v.snowflake(path, guildID, "guild")
+		v.permissions(path, c.GuildPermissions[guildID])
+	}
+	v.required("dataDir", c.DataDir)
+	switch c.Storage {
+	case "", "jsonl":
+	case "bolt":
+		v.required("databaseFile", c.DatabaseFile)
+	default:
+		v.add("storage", "%q must be \"jsonl\" or \"bolt\"", c.Storage)
+	}
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+// jsonError adds the line and column to json syntax and type errors in the
+// config file at 'path'
+func jsonError(path string, file []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line, col := lineColumn(file, syntaxErr.Offset)
+		return fmt.Errorf("%s:%d:%d: invalid json, %w", path, line, col, err)
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		line, col := lineColumn(file, typeErr.Offset)
+		return fmt.Errorf("%s:%d:%d: %s must be a %s, not a %s", path, line, col, typeErr.Field, typeErr.Type, typeErr.Value)
+	}
+	return fmt.Errorf("%s: %w", path, err)
+}
+
+// lineColumn converts a byte offset in 'file' to a line and column from 1
+func lineColumn(file []byte, offset int64) (int, int) {
+	line, col := 1, 1
+	for i := int64(0); i < offset && i < int64(len(file)); i++ {
+		if file[i] == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
+
+// unknownFields returns a problem for each key in the json config 'file' that
+// doesn't match a field of Config. Maps like scoring.topFunds can have any key
+func unknownFields(file []byte) []Problem {
+	var raw any
+	if json.Unmarshal(file, &raw) != nil {
+		return nil
+	}
+	var unknown []string
+	walkUnknown("", raw, reflect.TypeOf(Config{}), &unknown)
+	sort.Strings(unknown)
+	problems := make([]Problem, len(unknown))
+	for i, path := range unknown {
+		problems[i] = Problem{Path: path, Message: "is not a config field, check the spelling"}
+	}
+	return problems
+}
+
+func walkUnknown(path string, value any, t reflect.Type, unknown *[]string) {
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return
+		}
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			if name != "" && name != "-" {
+				fields[strings.ToLower(name)] = t.Field(i).Type
+			}
+		}
+		for key, fieldValue := range object {
+			fieldPath := key
+			if path != "" {
+				fieldPath = path + "." + key
+			}
+			// encoding/json matches field names case insensitively
+			fieldType, ok := fields[strings.ToLower(key)]
+			if !ok {
+				*unknown = append(*unknown, fieldPath)
+				continue
+			}
+			walkUnknown(fieldPath, fieldValue, fieldType, unknown)
+		}
+	case reflect.Map:
+		// any key is allowed, only the values are checked
+		object, ok := value.(map[string]any)
+		if !ok {
+			return
+		}
+		for key, elem := range object {
+			walkUnknown(path+"."+key, elem, t.Elem(), unknown)
+		}
+	case reflect.Slice:
+		array, ok := value.([]any)
+		if !ok {
+			return
+		}
+		for i, elem := range array {
+			walkUnknown(fmt.Sprintf("%s[%d]", path, i), elem, t.Elem(), unknown)
+		}
+	}
+}
@@ -4,13 +4,23 @@ import "time"
 
 const (
 	PostURL = "https://api.cryptorank.io/v0/funding-rounds-v2"
+	// ProjectURL is formatted with a project's cryptorank key
+	ProjectURL = "https://api.cryptorank.io/v0/coins/%s"
 )
 
 const (
 	RoundsFileName              = "rounds.jsonl"
 	ProtocolsFileName           = "protocols.jsonl"
+	FundsFileName               = "funds.jsonl"
+	SearchCacheFileName         = "search_cache.jsonl"
+	SearchUsageFileName         = "search_usage.jsonl"
 	UnprocessedMessagesFileName = "unprocessed_messages.jsonl"
+	SettingsFileName            = "settings.jsonl"
+	AuditFileName               = "audit.jsonl"
 	GoogleSecretsEnvFileName    = "googlesecrets.env"
+	// SchemaKey is the key of the header line at the top of every .jsonl
+	// store, its value is the schema version the file was written with
+	SchemaKey = "_schema"
 )
 
 type MessageType int
@@ -18,6 +28,7 @@ type MessageType int
 const (
 	RoundMsg MessageType = iota
 	GoogleResult
+	AutoLinkNotice
 )
 
 const (
@@ -25,3 +36,16 @@ const (
 	// MaxOverwriteAttempts is the number of attempts the program will try to overwrite a file in OverwriteFile() function. Includes the initial attempt. Must be 1 or more
 	MaxWriteAttempts = 3
 )
+
+const (
+	// MaxRequestAttempts is the number of attempts made at a cryptorank or
+	// discord request in the daily job before giving up. Includes the initial
+	// attempt. Must be 1 or more
+	MaxRequestAttempts = 5
+	// RequestRetryBaseDelay is the delay before the first retry, it doubles on
+	// each retry after that up to RequestRetryMaxDelay
+	RequestRetryBaseDelay = time.Second * 5
+	RequestRetryMaxDelay  = time.Minute * 5
+	// RequestTimeout is the timeout of a single http request to cryptorank
+	RequestTimeout = time.Second * 30
+)
@@ -33,13 +33,30 @@ func (this UnprocessedMessages) IsAppended() bool {
 }
 
 type UnprocessedMessage struct {
-	Type         MessageType
-	ProtocolName string
-	Embeds       []*discordgo.MessageEmbed
-	ParentMsgID  string
-	Start        int
-	New          bool `json:"-"`
-	Changed      bool `json:"-"`
+	Type            MessageType
+	ProtocolName    string
+	Symbol          string
+	Embeds          []*discordgo.MessageEmbed
+	URLs            []string
+	ChannelID       string
+	ParentMsgID     string
+	ParentChannelID string
+	Start           int
+	PreviousURL     string
+	New             bool `json:"-"`
+	Changed         bool `json:"-"`
+}
+
+// ResultURL returns the url of the Google search result at index 'idx'. Older
+// messages saved without URLs fall back to the url of the result's embed
+func (this UnprocessedMessage) ResultURL(idx int) (string, bool) {
+	if idx < len(this.URLs) {
+		return this.URLs[idx], true
+	}
+	if idx < len(this.Embeds) {
+		return this.Embeds[idx].URL, true
+	}
+	return "", false
 }
 
 type Protocols struct {
@@ -49,13 +66,95 @@ type Protocols struct {
 }
 
 type Protocol struct {
-	Name       string
-	TwitterURL string
-	New        bool `json:"-"`
+	Name          string
+	Key           string
+	TwitterURL    string
+	TwitterHandle string
+	Website       string
+	Docs          string
+	Discord       string
+	GitHub        string
+	Telegram      string
+	Chains        []string
+	ThreadID      string
+	New           bool `json:"-"`
+}
+
+type Funds struct {
+	M        map[string]Fund
+	Modified bool
+}
+
+// Fund is an investor profile keyed by the fund's cryptorank key. Rounds holds
+// every funding round the bot has seen the fund take part in, oldest first
+type Fund struct {
+	Name             string
+	Key              string
+	Image            string
+	Tier             int
+	Type             string
+	Category         string
+	TotalInvestments int
+	Rounds           []FundRound
+}
+
+// FundRound is a short record of a single funding round a fund took part in.
+// FundCount is the number of funds in the round and is used to estimate the
+// fund's ticket size
+type FundRound struct {
+	Protocol  string
+	Date      time.Time
+	Stage     string
+	Category  string
+	Raise     int
+	FundCount int
+}
+
+// SearchCache holds Google search results keyed by query and start result so
+// paging back and forth through results doesn't cost search quota
+type SearchCache struct {
+	M        map[string]CachedSearch
+	Modified bool
+}
+
+type CachedSearch struct {
+	Query     string
+	Start     int
+	Results   []SearchResult
+	FetchedAt time.Time
+}
+
+type SearchResult struct {
+	Title        string
+	FormattedURL string
+	Snippet      string
+}
+
+// SearchUsage counts the Google searches made each day keyed by the date in
+// Google's quota time zone (2006-01-02 format)
+type SearchUsage struct {
+	M        map[string]int
+	Modified bool
+}
+
+// AuditEntry records an action taken by a user through a bot command or
+// reaction. Protocol is the protocol acted on and Setting the config setting or
+// protocol field changed, either can be empty. Old and New are the json encoded
+// values before and after the change, empty when there was no value
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	UserID   string    `json:"userID"`
+	Username string    `json:"username"`
+	Action   string    `json:"action"`
+	Protocol string    `json:"protocol,omitempty"`
+	Setting  string    `json:"setting"`
+	Old      string    `json:"old"`
+	New      string    `json:"new"`
 }
 
 type Round struct {
 	Name       string
+	Date       string
 	Desc       string
 	Stage      string
 	Raise      string
@@ -63,6 +162,7 @@ type Round struct {
 	Category   string
 	Tier1Funds string
 	Tier2Funds string
+	Score      float64
 }
 
 type Resp struct {
@@ -91,27 +191,55 @@ type RespData struct {
 		RelatedEntity  any    `json:"relatedEntity"`
 		FollowersCount int    `json:"followersCount"`
 	} `json:"topFollowers"`
-	FollowersCount   int `json:"followersCount"`
-	TwitterAccountID int `json:"twitterAccountId"`
-	TwitterScore     int `json:"twitterScore"`
-	Funds            []struct {
-		Name     string `json:"name"`
-		Key      string `json:"key"`
-		Image    string `json:"image"`
-		Tier     int    `json:"tier"`
-		Type     string `json:"type"`
-		Category struct {
-			ID   int    `json:"id"`
-			Slug string `json:"slug"`
-			Name string `json:"name"`
-		} `json:"category"`
-		TotalInvestments int `json:"totalInvestments"`
-	} `json:"funds"`
-	Valuation any `json:"valuation"`
-	Category  struct {
+	FollowersCount   int        `json:"followersCount"`
+	TwitterAccountID int        `json:"twitterAccountId"`
+	TwitterScore     int        `json:"twitterScore"`
+	Funds            []RespFund `json:"funds"`
+	Valuation        any        `json:"valuation"`
+	Category         struct {
 		Name string `json:"name"`
 		Key  string `json:"key"`
 	} `json:"category"`
 	HasFundingRounds bool      `json:"hasFundingRounds"`
 	CreatedAt        time.Time `json:"createdAt"`
 }
+
+type RespFund struct {
+	Name     string `json:"name"`
+	Key      string `json:"key"`
+	Image    string `json:"image"`
+	Tier     int    `json:"tier"`
+	Type     string `json:"type"`
+	Category struct {
+		ID   int    `json:"id"`
+		Slug string `json:"slug"`
+		Name string `json:"name"`
+	} `json:"category"`
+	TotalInvestments int `json:"totalInvestments"`
+}
+
+// ProjectResp is the response from cryptorank's project endpoint, only the
+// fields the bot uses are decoded
+type ProjectResp struct {
+	Data struct {
+		Key       string            `json:"key"`
+		Name      string            `json:"name"`
+		Links     []ProjectLink     `json:"links"`
+		Contracts []ProjectContract `json:"contracts"`
+	} `json:"data"`
+}
+
+type ProjectLink struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// ProjectContract is a token contract of a cryptorank project, the platform is
+// the chain it's deployed on
+type ProjectContract struct {
+	Address  string `json:"address"`
+	Platform struct {
+		Key  string `json:"key"`
+		Name string `json:"name"`
+	} `json:"platform"`
+}
@@ -1,6 +1,14 @@
 package data
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
 
 // temporary is an error interface that implements the Temporary method. It
 // indicates an error is "temporary" and thus the operations being performed
@@ -9,11 +17,11 @@ type temporary interface {
 	Temporary() bool
 }
 
-// IsTemporary is a helper function that returns true if the arg 'err' implements
-// the 'temporary' interface
+// IsTemporary is a helper function that returns true if the arg 'err', or any
+// error it wraps, implements the 'temporary' interface
 func IsTemporary(err error) bool {
-	te, ok := err.(temporary)
-	return ok && te.Temporary()
+	var te temporary
+	return errors.As(err, &te) && te.Temporary()
 }
 
 type JsonMarshalError struct {
@@ -35,3 +43,146 @@ func (e ReadWriteFileError) Error() string {
 func (e ReadWriteFileError) Temporary() bool {
 	return true
 }
+
+func (e JsonMarshalError) Unwrap() error {
+	return e.OriginalErr
+}
+
+func (e ReadWriteFileError) Unwrap() error {
+	return e.OriginalErr
+}
+
+// HTTPRequestError is returned when an http request couldn't be sent or its
+// response couldn't be read. These are usually network problems so they are
+// treated as temporary
+type HTTPRequestError struct {
+	URL         string
+	OriginalErr error
+}
+
+func (e HTTPRequestError) Error() string {
+	return fmt.Sprintf("failed http request to %s | %v", e.URL, e.OriginalErr)
+}
+
+func (e HTTPRequestError) Unwrap() error {
+	return e.OriginalErr
+}
+
+func (e HTTPRequestError) Temporary() bool {
+	return true
+}
+
+// HTTPStatusError is returned when an http request gets a response with a
+// status code other than 200. Rate limits and server errors are temporary
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d from %s | %s", e.StatusCode, e.URL, e.Body)
+}
+
+func (e HTTPStatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// DiscordError wraps an error returned by the discord API. It's temporary when
+// discord had a server error, rate limited the request past discordgo's own
+// retries or the request failed on the network
+type DiscordError struct {
+	OriginalErr error
+}
+
+func (e DiscordError) Error() string {
+	return fmt.Sprintf("discord request failed | %v", e.OriginalErr)
+}
+
+func (e DiscordError) Unwrap() error {
+	return e.OriginalErr
+}
+
+func (e DiscordError) Temporary() bool {
+	var restErr *discordgo.RESTError
+	if errors.As(e.OriginalErr, &restErr) && restErr.Response != nil {
+		return restErr.Response.StatusCode == http.StatusTooManyRequests || restErr.Response.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(e.OriginalErr, &netErr)
+}
+
+// DiscordSendError wraps an error returned by a discord request that creates
+// something, like sending a message. Such requests aren't idempotent, a retry
+// after a timeout can post twice. It's only temporary when the request
+// certainly never created anything: discord rate limited it or the
+// connection to discord couldn't be made
+type DiscordSendError struct {
+	OriginalErr error
+}
+
+func (e DiscordSendError) Error() string {
+	return fmt.Sprintf("discord request failed | %v", e.OriginalErr)
+}
+
+func (e DiscordSendError) Unwrap() error {
+	return e.OriginalErr
+}
+
+func (e DiscordSendError) Temporary() bool {
+	var restErr *discordgo.RESTError
+	if errors.As(e.OriginalErr, &restErr) && restErr.Response != nil {
+		return restErr.Response.StatusCode == http.StatusTooManyRequests
+	}
+	var dnsErr *net.DNSError
+	if errors.As(e.OriginalErr, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(e.OriginalErr, &opErr) && opErr.Op == "dial"
+}
+
+// RetriesExhaustedError is returned when an operation still failed with a
+// temporary error after every retry
+type RetriesExhaustedError struct {
+	Attempts    int
+	OriginalErr error
+}
+
+func (e RetriesExhaustedError) Error() string {
+	return fmt.Sprintf("giving up after %d attempts | %v", e.Attempts, e.OriginalErr)
+}
+
+func (e RetriesExhaustedError) Unwrap() error {
+	return e.OriginalErr
+}
+
+// SearchQuotaError is returned when the daily Google custom search quota has
+// been used up. Searching is turned off until ResetAt
+type SearchQuotaError struct {
+	ResetAt     time.Time
+	OriginalErr error
+}
+
+func (e SearchQuotaError) Error() string {
+	if e.OriginalErr == nil {
+		return fmt.Sprintf("daily search quota used up, search is off until %s", e.ResetAt.Format(time.RFC1123))
+	}
+	return fmt.Sprintf("daily search quota used up, search is off until %s | %v", e.ResetAt.Format(time.RFC1123), e.OriginalErr)
+}
+
+func (e SearchQuotaError) Unwrap() error {
+	return e.OriginalErr
+}
+
+// SchemaVersionError is returned when a store file was written with a newer
+// schema version than this build of the bot knows how to read
+type SchemaVersionError struct {
+	FileName  string
+	Version   int
+	Supported int
+}
+
+func (e SchemaVersionError) Error() string {
+	return fmt.Sprintf("file %s has schema version %d, this bot only supports up to version %d", e.FileName, e.Version, e.Supported)
+}
@@ -5,6 +5,7 @@ go 1.21.3
 require (
 	github.com/bwmarrin/discordgo v0.28.1
 	github.com/joho/godotenv v1.5.1
+	go.etcd.io/bbolt v1.3.10
 	google.golang.org/api v0.196.0
 )
 
@@ -1,55 +1,221 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
 	"log"
+	"os"
+	"strings"
 
-	"github.com/bwmarrin/discordgo"
 	"github.com/joho/godotenv"
 	"github.com/readysetliqd/airdrop-discord-bot-go/bot"
 	"github.com/readysetliqd/airdrop-discord-bot-go/config"
 	"github.com/readysetliqd/airdrop-discord-bot-go/data"
 )
 
-var s *discordgo.Session
-var protocols *data.Protocols
-var unprocessedMessages *data.UnprocessedMessages
+// command line flags besides the generated config overrides
+var (
+	configPath        = flag.String("config", "", "path of the json config file, defaults to $AIRDROPBOT_CONFIG or config.json if it exists")
+	googleSecretsPath = flag.String("google-secrets", "", "path of the .env file with GOOGLE_API_KEY and GOOGLE_CX, defaults to $AIRDROPBOT_GOOGLE_SECRETS or "+data.GoogleSecretsEnvFileName)
+)
+
+// usage lists the subcommands, the bot runs when none is given
+const usage = `Usage: %s [flags] [subcommand]
+
+Subcommands:
+  run                              run the bot, the default
+  validate-config [file]           check the config and exit
+  import                           copy the .jsonl files into the database
+  import [-replace] <bucket> <file>
+                                   store the records of a .json or .csv file made by export
+  export <bucket> [file]           write a bucket to a .json or .csv file, json to stdout without one
+  list-protocols                   print every protocol and its twitter
+  set-twitter <name> <url>         set a protocol's twitter, an empty url clears it
+  compact                          rewrite the .jsonl files with one line per record
+  backfill -from YYYY-MM-DD -to YYYY-MM-DD [-dry-run] [-channel id]
+                                   run the daily job for past dates
+  replay <file or dir>             send archived cryptorank responses through the embeds offline
+  register-commands                register the slash commands without running the bot
+  unregister-commands              remove the bot's slash commands
+
+Run the subcommands that change data while the bot is stopped.
+
+Flags:
+`
 
 func main() {
+	configFlags := config.RegisterFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	subcommand, args := "run", flag.Args()
+	if len(args) > 0 {
+		subcommand, args = args[0], args[1:]
+	}
+
+	// "validate-config" checks the config and exits without connecting to discord
+	if subcommand == "validate-config" {
+		path := resolveConfigPath()
+		if len(args) > 0 {
+			path = args[0]
+		}
+		validateConfig(path, configFlags())
+		return
+	}
+
 	// load configs, secrets, and backup data into memory
-	loadConfig()
-	loadGoogleSecrets()
-	err := bot.Start()
+	path, flags := resolveConfigPath(), configFlags()
+	cfg := loadConfig(path, flags)
+
+	var err error
+	switch subcommand {
+	case "run":
+		loadGoogleSecrets()
+		err = bot.Start(cfg, func() (*config.Config, error) {
+			return config.Load(path, flags)
+		})
+	case "import":
+		importFlags := flag.NewFlagSet("import", flag.ExitOnError)
+		replace := importFlags.Bool("replace", false, "delete the records missing from the file")
+		importFlags.Parse(args)
+		switch importFlags.NArg() {
+		case 0:
+			// without a file the .jsonl files are copied into the database
+			err = bot.ImportJsonl(cfg)
+		case 2:
+			err = bot.Import(cfg, importFlags.Arg(0), importFlags.Arg(1), *replace)
+		default:
+			err = errors.New("import needs a bucket and a file, or nothing to import the .jsonl files")
+		}
+	case "export":
+		if len(args) == 0 {
+			err = errors.New("export needs a bucket")
+			break
+		}
+		fileName := ""
+		if len(args) > 1 {
+			fileName = args[1]
+		}
+		err = bot.Export(cfg, args[0], fileName)
+	case "list-protocols":
+		err = bot.ListProtocols(cfg)
+	case "set-twitter":
+		if len(args) != 2 {
+			err = errors.New("set-twitter needs a protocol name and a url")
+			break
+		}
+		err = bot.SetTwitter(cfg, args[0], args[1])
+	case "compact":
+		err = bot.Compact(cfg)
+	case "backfill":
+		backfillFlags := flag.NewFlagSet("backfill", flag.ExitOnError)
+		from := backfillFlags.String("from", "", "first date of the rounds as YYYY-MM-DD")
+		to := backfillFlags.String("to", "", "last date of the rounds as YYYY-MM-DD")
+		dryRun := backfillFlags.Bool("dry-run", false, "print what would be posted without posting")
+		channelID := backfillFlags.String("channel", "", "channel to post in instead of the announcement channel, the rounds are not marked announced")
+		backfillFlags.Parse(args)
+		loadGoogleSecrets()
+		err = bot.Backfill(cfg, *from, *to, *dryRun, *channelID)
+	case "replay":
+		// sends archived cryptorank responses through the embeds offline and
+		// writes what would be posted to stdout
+		if len(args) == 0 {
+			err = errors.New("replay needs an archived response file or directory")
+			break
+		}
+		err = bot.Replay(cfg, args[0])
+	case "register-commands":
+		err = bot.RegisterCommands(cfg)
+	case "unregister-commands":
+		err = bot.UnregisterCommands(cfg)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	//TODO move this to setup
-	//DEBUG
-	// emojis, err := s.GuildEmojis("1227637875428950136")
-	// if err != nil {
-	// 	log.Println(err)
-	// }
-	// fmt.Println("emojis")
-	// for _, emoji := range emojis {
-	// 	fmt.Println(*emoji)
-	// }
-	//^DEBUG
+		log.Fatalf("%s | %v", subcommand, err)
+	}
 }
 
 // loadGoogleSecrets is a helper function that loads the google secrets from
-// the .env file into the os environment. It shuts down program on any errors
+// the .env file into the os environment, variables already set are kept. The
+// file can be left out if GOOGLE_API_KEY and GOOGLE_CX are set, either
+// directly or as paths to files holding them with GOOGLE_API_KEY_FILE and
+// GOOGLE_CX_FILE. It shuts down program on any errors
 func loadGoogleSecrets() {
-	err := godotenv.Load(data.GoogleSecretsEnvFileName)
+	for _, name := range []string{"GOOGLE_API_KEY", "GOOGLE_CX"} {
+		path, ok := os.LookupEnv(name + "_FILE")
+		if !ok {
+			continue
+		}
+		secret, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("error reading %s_FILE | %v", name, err)
+		}
+		os.Setenv(name, strings.TrimSpace(string(secret)))
+	}
+
+	path := *googleSecretsPath
+	if path == "" {
+		path = os.Getenv(config.EnvPrefix + "GOOGLE_SECRETS")
+	}
+	if path == "" {
+		path = data.GoogleSecretsEnvFileName
+		if os.Getenv("GOOGLE_API_KEY") != "" && os.Getenv("GOOGLE_CX") != "" {
+			if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+				return
+			}
+		}
+	}
+	err := godotenv.Load(path)
 	if err != nil {
 		log.Fatal("error loading .env files |", err)
 	}
 }
 
-// loadConfig loads the config.json file into the config struct to be used by
-// any package importing the config package. It logs fatal on any errors
-func loadConfig() {
-	err := config.ReadConfig()
+// resolveConfigPath returns the path of the config file from the -config flag
+// or $AIRDROPBOT_CONFIG. Otherwise it's config.json if it exists, or empty to
+// configure the bot only from the environment and flags
+func resolveConfigPath() string {
+	if *configPath != "" {
+		return *configPath
+	}
+	if path := os.Getenv(config.EnvPrefix + "CONFIG"); path != "" {
+		return path
+	}
+	if _, err := os.Stat("config.json"); err == nil {
+		return "config.json"
+	}
+	return ""
+}
+
+// loadConfig loads the config file, environment and flags into the config
+// struct passed to the bot. It logs fatal on any errors
+func loadConfig(path string, flags map[string]string) *config.Config {
+	// stderr keeps stdout to what subcommands print
+	fmt.Fprintln(os.Stderr, "Reading config...")
+	cfg, err := config.Load(path, flags)
 	if err != nil {
 		log.Fatal("error reading configs |", err)
 	}
+	return cfg
+}
+
+// validateConfig checks the config from the file at 'path', the environment
+// and 'flags' and prints every problem found. It exits with status 1 if there
+// are any
+func validateConfig(path string, flags map[string]string) {
+	_, err := config.Load(path, flags)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if path == "" {
+		path = "config from the environment and flags"
+	}
+	fmt.Printf("%s is valid\n", path)
 }
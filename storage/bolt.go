@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+	bolt "go.etcd.io/bbolt"
+)
+
+// indexSeparator separates the indexed value from the record key in the keys
+// of index buckets
+const indexSeparator = "\x00"
+
+// BoltStorage is a Storage in a single bolt database file. Every index is kept
+// in its own bucket named <bucket>/<index> with keys of the indexed value and
+// record key, so lookups are a prefix scan
+type BoltStorage struct {
+	db       *bolt.DB
+	readOnly bool
+}
+
+// metaBucket holds the schema version of every bucket keyed by the bucket's
+// name
+const metaBucket = "_meta"
+
+// MigrateFunc upgrades the records of 'bucket' from schema version 'from' to
+// the current version in place. Records deleted from 'records' are deleted
+// from the bucket
+type MigrateFunc func(bucket string, from int, records map[string]json.RawMessage) error
+
+// OpenBolt opens or creates the bolt database at 'path' and makes sure every
+// bucket and index bucket exists. 'versions' is the current schema version of
+// each bucket, the same as the version of its .jsonl file. Buckets stored with
+// an older version are upgraded with 'migrate' and a newer version returns
+// data.SchemaVersionError. Buckets without a recorded version were created
+// before versions were recorded and hold records of the current version
+func OpenBolt(path string, versions map[string]int, migrate MigrateFunc) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0666, &bolt.Options{Timeout: time.Second * 5})
+	if err != nil {
+		return nil, data.ReadWriteFileError{OriginalErr: err}
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+		if err != nil {
+			return err
+		}
+		for bucket := range BucketFiles {
+			_, err := tx.CreateBucketIfNotExists([]byte(bucket))
+			if err != nil {
+				return err
+			}
+			for index := range Indexes[bucket] {
+				_, err = tx.CreateBucketIfNotExists(indexBucketName(bucket, index))
+				if err != nil {
+					return err
+				}
+			}
+			err = migrateBucket(boltTx{tx: tx}, meta, path, bucket, versions[bucket], migrate)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening buckets in %s | %w", path, err)
+	}
+	return &BoltStorage{db: db}, nil
+}
+
+// OpenBoltReadOnly opens the existing bolt database at 'path' without writing
+// to it, for storage kept in memory by an Overlay. 'versions' is checked like
+// in OpenBolt but buckets can't be migrated, an older or newer bucket returns
+// data.SchemaVersionError. Buckets missing from the database read as empty
+func OpenBoltReadOnly(path string, versions map[string]int) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0666, &bolt.Options{Timeout: time.Second * 5, ReadOnly: true})
+	if err != nil {
+		return nil, data.ReadWriteFileError{OriginalErr: err}
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte(metaBucket))
+		for bucket := range BucketFiles {
+			if meta == nil || meta.Get([]byte(bucket)) == nil {
+				continue
+			}
+			version, err := strconv.Atoi(string(meta.Get([]byte(bucket))))
+			if err != nil {
+				return fmt.Errorf("schema version of bucket %s | %w", bucket, err)
+			}
+			if version != versions[bucket] {
+				return data.SchemaVersionError{FileName: path + " bucket " + bucket, Version: version, Supported: versions[bucket]}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening buckets in %s | %w", path, err)
+	}
+	return &BoltStorage{db: db, readOnly: true}, nil
+}
+
+// migrateBucket upgrades the records of 'bucket' to schema version 'current'
+// with 'migrate' and records the version in 'meta'. Returns
+// data.SchemaVersionError if the bucket is newer than 'current'
+func migrateBucket(t boltTx, meta *bolt.Bucket, path, bucket string, current int, migrate MigrateFunc) error {
+	version := current
+	if raw := meta.Get([]byte(bucket)); raw != nil {
+		var err error
+		version, err = strconv.Atoi(string(raw))
+		if err != nil {
+			return fmt.Errorf("schema version %q of bucket %s | %w", raw, bucket, err)
+		}
+	}
+	if version > current {
+		return data.SchemaVersionError{FileName: path + " bucket " + bucket, Version: version, Supported: current}
+	}
+	if version < current {
+		records := map[string]json.RawMessage{}
+		err := t.ForEach(bucket, func(key string, raw json.RawMessage) error {
+			records[key] = raw
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(records))
+		for key := range records {
+			keys = append(keys, key)
+		}
+		err = migrate(bucket, version, records)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if _, ok := records[key]; !ok {
+				err = t.Delete(bucket, key)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for key, raw := range records {
+			err = t.Put(bucket, key, raw)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return meta.Put([]byte(bucket), []byte(strconv.Itoa(current)))
+}
+
+func (b *BoltStorage) View(fn func(tx Tx) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx: tx, readOnly: b.readOnly})
+	})
+}
+
+func (b *BoltStorage) Update(fn func(tx Tx) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx: tx, readOnly: b.readOnly})
+	})
+}
+
+func (b *BoltStorage) Close() error {
+	return b.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+	// readOnly is set for a database opened with OpenBoltReadOnly
+	readOnly bool
+}
+
+// errMissingBucket is returned by bucket for a bucket missing from a read only
+// database, reads treat it as empty
+var errMissingBucket = errors.New("bucket missing from read only database")
+
+func (t boltTx) bucket(name string) (*bolt.Bucket, error) {
+	bucket := t.tx.Bucket([]byte(name))
+	if bucket == nil && t.readOnly {
+		if _, ok := BucketFiles[name]; ok {
+			return nil, errMissingBucket
+		}
+	}
+	if bucket == nil {
+		return nil, fmt.Errorf("unknown bucket %s", name)
+	}
+	return bucket, nil
+}
+
+func (t boltTx) Get(bucket, key string, v any) (bool, error) {
+	b, err := t.bucket(bucket)
+	if errors.Is(err, errMissingBucket) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	raw := b.Get([]byte(key))
+	if raw == nil {
+		return false, nil
+	}
+	err = json.Unmarshal(raw, v)
+	if err != nil {
+		return false, data.JsonMarshalError{OriginalErr: err}
+	}
+	return true, nil
+}
+
+func (t boltTx) Put(bucket, key string, v any) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return data.JsonMarshalError{OriginalErr: err}
+	}
+	err = t.unindex(bucket, key, b.Get([]byte(key)))
+	if err != nil {
+		return err
+	}
+	err = b.Put([]byte(key), raw)
+	if err != nil {
+		return err
+	}
+	for index, values := range IndexValues(bucket, raw) {
+		indexBucket := t.tx.Bucket(indexBucketName(bucket, index))
+		for _, value := range values {
+			err = indexBucket.Put(indexKey(value, key), nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t boltTx) Delete(bucket, key string) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	err = t.unindex(bucket, key, b.Get([]byte(key)))
+	if err != nil {
+		return err
+	}
+	return b.Delete([]byte(key))
+}
+
+// unindex removes the index entries of the record 'raw' stored under 'key'
+func (t boltTx) unindex(bucket, key string, raw []byte) error {
+	if raw == nil {
+		return nil
+	}
+	for index, values := range IndexValues(bucket, raw) {
+		indexBucket := t.tx.Bucket(indexBucketName(bucket, index))
+		for _, value := range values {
+			err := indexBucket.Delete(indexKey(value, key))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t boltTx) ForEach(bucket string, fn func(key string, raw json.RawMessage) error) error {
+	b, err := t.bucket(bucket)
+	if errors.Is(err, errMissingBucket) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return b.ForEach(func(k, v []byte) error {
+		// bolt values are only valid during the transaction
+		return fn(string(k), append(json.RawMessage(nil), v...))
+	})
+}
+
+func (t boltTx) Lookup(bucket, index, value string) ([]string, error) {
+	indexBucket := t.tx.Bucket(indexBucketName(bucket, index))
+	if indexBucket == nil && t.readOnly && Indexes[bucket][index] != nil {
+		return nil, nil
+	}
+	if indexBucket == nil {
+		return nil, errors.New("unknown index " + index + " on bucket " + bucket)
+	}
+	prefix := indexKey(NormalizeIndexValue(value), "")
+	var keys []string
+	c := indexBucket.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, string(k[len(prefix):]))
+	}
+	return keys, nil
+}
+
+// indexBucketName is the name of the bucket holding 'index' of 'bucket'
+func indexBucketName(bucket, index string) []byte {
+	return []byte(bucket + "/" + index)
+}
+
+// indexKey is the key of the index entry for the record 'key' indexed under
+// 'value'
+func indexKey(value, key string) []byte {
+	return []byte(value + indexSeparator + key)
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+)
+
+// boltVersions returns the schema version 'version' for every bucket
+func boltVersions(version int) map[string]int {
+	versions := make(map[string]int, len(BucketFiles))
+	for bucket := range BucketFiles {
+		versions[bucket] = version
+	}
+	return versions
+}
+
+// noMigrations fails the test if any bucket is migrated
+func noMigrations(t *testing.T) MigrateFunc {
+	return func(bucket string, from int, records map[string]json.RawMessage) error {
+		t.Errorf("bucket %s migrated from version %d", bucket, from)
+		return nil
+	}
+}
+
+func TestBoltSchemaVersions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bot.db")
+	db, err := OpenBolt(path, boltVersions(1), noMigrations(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx Tx) error {
+		err := tx.Put(ProtocolsBucket, "Aurora", map[string]string{"Name": "Aurora", "TwitterURL": "old"})
+		if err != nil {
+			return err
+		}
+		return tx.Put(ProtocolsBucket, "Gone", map[string]string{"Name": "Gone"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// reopening at the same version leaves the buckets alone
+	db, err = OpenBolt(path, boltVersions(1), noMigrations(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// a bot that only knows an older version refuses the database
+	_, err = OpenBolt(path, boltVersions(0), noMigrations(t))
+	var versionErr data.SchemaVersionError
+	if !errors.As(err, &versionErr) || versionErr.Version != 1 || versionErr.Supported != 0 {
+		t.Fatalf("opening a newer database returned %v, want data.SchemaVersionError", err)
+	}
+
+	// a newer version migrates every bucket once
+	migrated := map[string]int{}
+	db, err = OpenBolt(path, boltVersions(2), func(bucket string, from int, records map[string]json.RawMessage) error {
+		migrated[bucket] = from
+		if bucket == ProtocolsBucket {
+			records["Aurora"] = json.RawMessage(`{"Name":"Aurora","TwitterURL":"new"}`)
+			delete(records, "Gone")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrated) != len(BucketFiles) || migrated[ProtocolsBucket] != 1 {
+		t.Errorf("migrated buckets %v, want every bucket from version 1", migrated)
+	}
+	var protocol map[string]string
+	err = db.View(func(tx Tx) error {
+		ok, err := tx.Get(ProtocolsBucket, "Aurora", &protocol)
+		if err != nil || !ok {
+			t.Errorf("getting migrated record | %v", err)
+		}
+		ok, err = tx.Get(ProtocolsBucket, "Gone", &protocol)
+		if err != nil || ok {
+			t.Errorf("record deleted by the migration is still stored | %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if protocol["TwitterURL"] != "new" {
+		t.Errorf("migrated record is %v", protocol)
+	}
+
+	db, err = OpenBolt(path, boltVersions(2), noMigrations(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+}
+
+func TestBoltReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bot.db")
+	db, err := OpenBolt(path, boltVersions(1), noMigrations(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx Tx) error {
+		return tx.Put(ProtocolsBucket, "Aurora", map[string]string{"Name": "Aurora"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	_, err = OpenBoltReadOnly(path, boltVersions(2))
+	var versionErr data.SchemaVersionError
+	if !errors.As(err, &versionErr) {
+		t.Fatalf("opening an older database read only returned %v, want data.SchemaVersionError", err)
+	}
+
+	db, err = OpenBoltReadOnly(path, boltVersions(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = db.View(func(tx Tx) error {
+		var protocol map[string]string
+		ok, err := tx.Get(ProtocolsBucket, "Aurora", &protocol)
+		if err != nil || !ok {
+			t.Errorf("getting stored record | %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx Tx) error {
+		return tx.Put(ProtocolsBucket, "Beacon", map[string]string{"Name": "Beacon"})
+	})
+	if err == nil {
+		t.Error("writing to a read only database succeeded")
+	}
+}
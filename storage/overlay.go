@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+)
+
+// Overlay is a Storage that reads from another Storage but keeps every write
+// in memory, the base is never changed. Shadow mode uses it so state changes
+// are simulated without being stored
+type Overlay struct {
+	mu      sync.Mutex
+	base    Storage
+	records map[string]map[string]json.RawMessage
+	deleted map[string]map[string]bool
+}
+
+// NewOverlay returns an Overlay reading from 'base'
+func NewOverlay(base Storage) *Overlay {
+	return &Overlay{
+		base:    base,
+		records: map[string]map[string]json.RawMessage{},
+		deleted: map[string]map[string]bool{},
+	}
+}
+
+func (o *Overlay) View(fn func(tx Tx) error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.base.View(func(tx Tx) error {
+		return fn(&overlayTx{o: o, base: tx})
+	})
+}
+
+func (o *Overlay) Update(fn func(tx Tx) error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	t := &overlayTx{
+		o:        o,
+		writable: true,
+		puts:     map[string]map[string]json.RawMessage{},
+		deletes:  map[string]map[string]bool{},
+	}
+	err := o.base.View(func(tx Tx) error {
+		t.base = tx
+		return fn(t)
+	})
+	if err != nil {
+		return err
+	}
+	for bucket, keys := range t.deletes {
+		for key := range keys {
+			delete(o.records[bucket], key)
+			setKey(o.deleted, bucket, key, true)
+		}
+	}
+	for bucket, records := range t.puts {
+		for key, raw := range records {
+			setKey(o.records, bucket, key, raw)
+			delete(o.deleted[bucket], key)
+		}
+	}
+	return nil
+}
+
+// Close closes the base storage, the writes kept in memory are dropped
+func (o *Overlay) Close() error {
+	return o.base.Close()
+}
+
+// overlayTx reads the writes of its own transaction first, then those of
+// earlier transactions and finally the base
+type overlayTx struct {
+	o        *Overlay
+	base     Tx
+	writable bool
+	puts     map[string]map[string]json.RawMessage
+	deletes  map[string]map[string]bool
+}
+
+// overlaid returns true if the base record under 'key' is hidden by a write
+func (t *overlayTx) overlaid(bucket, key string) bool {
+	_, put := t.puts[bucket][key]
+	_, written := t.o.records[bucket][key]
+	return put || written || t.deletes[bucket][key] || t.o.deleted[bucket][key]
+}
+
+// written returns the records of 'bucket' written and not deleted since the
+// overlay was created
+func (t *overlayTx) written(bucket string) map[string]json.RawMessage {
+	records := map[string]json.RawMessage{}
+	for key, raw := range t.o.records[bucket] {
+		records[key] = raw
+	}
+	for key, raw := range t.puts[bucket] {
+		records[key] = raw
+	}
+	for key := range t.deletes[bucket] {
+		delete(records, key)
+	}
+	return records
+}
+
+func (t *overlayTx) Get(bucket, key string, v any) (bool, error) {
+	if !t.overlaid(bucket, key) {
+		return t.base.Get(bucket, key, v)
+	}
+	raw, ok := t.written(bucket)[key]
+	if !ok {
+		return false, nil
+	}
+	err := json.Unmarshal(raw, v)
+	if err != nil {
+		return false, data.JsonMarshalError{OriginalErr: err}
+	}
+	return true, nil
+}
+
+func (t *overlayTx) Put(bucket, key string, v any) error {
+	if !t.writable {
+		return errors.New("put in a read only transaction")
+	}
+	if _, ok := BucketFiles[bucket]; !ok {
+		return fmt.Errorf("unknown bucket %s", bucket)
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return data.JsonMarshalError{OriginalErr: err}
+	}
+	setKey(t.puts, bucket, key, raw)
+	delete(t.deletes[bucket], key)
+	return nil
+}
+
+func (t *overlayTx) Delete(bucket, key string) error {
+	if !t.writable {
+		return errors.New("delete in a read only transaction")
+	}
+	if _, ok := BucketFiles[bucket]; !ok {
+		return fmt.Errorf("unknown bucket %s", bucket)
+	}
+	setKey(t.deletes, bucket, key, true)
+	delete(t.puts[bucket], key)
+	return nil
+}
+
+func (t *overlayTx) ForEach(bucket string, fn func(key string, raw json.RawMessage) error) error {
+	err := t.base.ForEach(bucket, func(key string, raw json.RawMessage) error {
+		if t.overlaid(bucket, key) {
+			return nil
+		}
+		return fn(key, raw)
+	})
+	if err != nil {
+		return err
+	}
+	for key, raw := range t.written(bucket) {
+		err = fn(key, raw)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *overlayTx) Lookup(bucket, index, value string) ([]string, error) {
+	baseKeys, err := t.base.Lookup(bucket, index, value)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, key := range baseKeys {
+		if !t.overlaid(bucket, key) {
+			keys = append(keys, key)
+		}
+	}
+	value = NormalizeIndexValue(value)
+	for key, raw := range t.written(bucket) {
+		for _, indexed := range IndexValues(bucket, raw)[index] {
+			if indexed == value {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+	return keys, nil
+}
+
+// setKey sets 'key' of 'bucket' in the nested map 'm', creating the bucket's
+// map if needed
+func setKey[T any](m map[string]map[string]T, bucket, key string, v T) {
+	if m[bucket] == nil {
+		m[bucket] = map[string]T{}
+	}
+	m[bucket][key] = v
+}
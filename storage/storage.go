@@ -0,0 +1,142 @@
+// Package storage is the persistence layer of the bot. Records are json
+// values stored by key in named buckets, the backend is either the original
+// .jsonl files or an embedded bolt database
+package storage
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/readysetliqd/airdrop-discord-bot-go/data"
+)
+
+// Bucket names, each bucket holds the records of one .jsonl store file
+const (
+	ProtocolsBucket           = "protocols"
+	UnprocessedMessagesBucket = "unprocessed_messages"
+	RoundsBucket              = "rounds"
+	FundsBucket               = "funds"
+	SearchCacheBucket         = "search_cache"
+	SearchUsageBucket         = "search_usage"
+	// SettingsBucket holds the config settings changed with /config by their
+	// json name in config.json
+	SettingsBucket = "settings"
+	AuditBucket    = "audit"
+)
+
+// BucketFiles maps every bucket to the .jsonl file it's stored in by the jsonl
+// backend and imported from into the database
+var BucketFiles = map[string]string{
+	ProtocolsBucket:           data.ProtocolsFileName,
+	UnprocessedMessagesBucket: data.UnprocessedMessagesFileName,
+	RoundsBucket:              data.RoundsFileName,
+	FundsBucket:               data.FundsFileName,
+	SearchCacheBucket:         data.SearchCacheFileName,
+	SearchUsageBucket:         data.SearchUsageFileName,
+	SettingsBucket:            data.SettingsFileName,
+	AuditBucket:               data.AuditFileName,
+}
+
+// Storage stores json records by key in buckets. All reads and writes happen
+// in a transaction, an Update is committed only if its function returns nil
+type Storage interface {
+	View(fn func(tx Tx) error) error
+	Update(fn func(tx Tx) error) error
+	Close() error
+}
+
+// Tx is a transaction on a Storage. Writes return an error in a View
+type Tx interface {
+	// Get decodes the record stored under 'key' into 'v' and returns false if
+	// there is none
+	Get(bucket, key string, v any) (bool, error)
+	// Put stores 'v' encoded as json under 'key', replacing any record there
+	Put(bucket, key string, v any) error
+	Delete(bucket, key string) error
+	// ForEach calls 'fn' for every record in the bucket, stopping at the first
+	// error
+	ForEach(bucket string, fn func(key string, raw json.RawMessage) error) error
+	// Lookup returns the keys of the records in the bucket that the secondary
+	// index 'index' maps to 'value', case insensitive
+	Lookup(bucket, index, value string) ([]string, error)
+}
+
+// IndexFunc returns the values a record is indexed under, an empty slice if it
+// isn't indexed
+type IndexFunc func(raw json.RawMessage) []string
+
+// Secondary index names
+const (
+	ProtocolIndex = "protocol"
+	DateIndex     = "date"
+	FundIndex     = "fund"
+	UserIndex     = "user"
+)
+
+// Indexes are the secondary indexes kept for each bucket by name
+var Indexes = map[string]map[string]IndexFunc{
+	RoundsBucket: {
+		ProtocolIndex: roundIndex(func(round data.Round) []string { return []string{round.Name} }),
+		DateIndex:     roundIndex(func(round data.Round) []string { return []string{round.Date} }),
+		FundIndex: roundIndex(func(round data.Round) []string {
+			return append(splitFunds(round.Tier1Funds), splitFunds(round.Tier2Funds)...)
+		}),
+	},
+	AuditBucket: {
+		ProtocolIndex: auditIndex(func(entry data.AuditEntry) []string { return []string{entry.Protocol} }),
+		UserIndex:     auditIndex(func(entry data.AuditEntry) []string { return []string{entry.UserID} }),
+	},
+}
+
+// IndexValues returns the normalized values the record 'raw' is indexed under
+// by every index of 'bucket', keyed by index name
+func IndexValues(bucket string, raw json.RawMessage) map[string][]string {
+	values := map[string][]string{}
+	for name, index := range Indexes[bucket] {
+		for _, value := range index(raw) {
+			if value = NormalizeIndexValue(value); value != "" {
+				values[name] = append(values[name], value)
+			}
+		}
+	}
+	return values
+}
+
+// NormalizeIndexValue returns the form index values are stored and looked up in
+func NormalizeIndexValue(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// roundIndex adapts 'fn' to an IndexFunc over rounds. Records that aren't
+// rounds aren't indexed
+func roundIndex(fn func(round data.Round) []string) IndexFunc {
+	return func(raw json.RawMessage) []string {
+		var round data.Round
+		if json.Unmarshal(raw, &round) != nil {
+			return nil
+		}
+		return fn(round)
+	}
+}
+
+// auditIndex adapts 'fn' to an IndexFunc over audit entries
+func auditIndex(fn func(entry data.AuditEntry) []string) IndexFunc {
+	return func(raw json.RawMessage) []string {
+		var entry data.AuditEntry
+		if json.Unmarshal(raw, &entry) != nil {
+			return nil
+		}
+		return fn(entry)
+	}
+}
+
+// splitFunds splits the comma separated fund names of a round
+func splitFunds(funds string) []string {
+	var names []string
+	for _, name := range strings.Split(funds, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}